@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/stefan79/gadget-cli/pkg/commands"
 	"github.com/urfave/cli/v2"
@@ -9,25 +14,42 @@ import (
 
 func main() {
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	session := commands.NewSession()
 	workActions := commands.NewWorkContext(session)
-	bootstrapActions, err := commands.NewBootstrapContext(session)
-	if err != nil {
-		panic(err)
-	}
-	deployActions, err := commands.NewDeployContext(session)
+	bootstrapActions, err := commands.NewBootstrapContext(ctx, session)
 	if err != nil {
-		panic(err)
+		fail(err)
 	}
+	deployActions := commands.NewDeployContext(session)
+	destroyActions := commands.NewDestroyContext(session)
+	statusActions := commands.NewStatusContext(session)
+	pushActions := commands.NewPushContext(session)
 
 	app := &cli.App{
+		Flags: session.CreateFlags(),
 		Commands: []*cli.Command{
 			workActions.CreateCommand(),
 			bootstrapActions.CreateCommand(),
 			deployActions.CreateCommand(),
+			deployActions.CreateSynthCommand(),
+			destroyActions.CreateCommand(),
+			statusActions.CreateCommand(),
+			pushActions.CreateCommand(),
 		},
 	}
-	if err := app.Run(os.Args); err != nil {
-		panic(err)
+	if err := app.RunContext(ctx, os.Args); err != nil {
+		fail(err)
+	}
+}
+
+// fail reports an error without a stack trace, an interrupted command exits like a shell would on Ctrl-C.
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	if errors.Is(err, context.Canceled) {
+		os.Exit(130)
 	}
+	os.Exit(1)
 }
@@ -0,0 +1,137 @@
+package adapter
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	// lambdaBootstrapName is the executable the provided.al2023 and provided.al2 runtimes start.
+	lambdaBootstrapName = "bootstrap"
+	lambdaBootstrapMode = os.FileMode(0755)
+	assetMode           = os.FileMode(0644)
+)
+
+type archiveEntry struct {
+	Name   string
+	Source string
+	Mode   os.FileMode
+}
+
+// archiveModified is used for every entry so identical content always results in an identical archive.
+// It is the earliest timestamp representable in the MS-DOS format used by zip.
+var archiveModified = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// writeArchive creates a deterministic zip file, entries are sorted by name and carry a fixed modification time.
+func writeArchive(targetFile string, entries []archiveEntry) error {
+	sorted := make([]archiveEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	zipFile, err := os.Create(targetFile)
+	if err != nil {
+		return err
+	}
+	defer zipFile.Close()
+
+	zipWriter := zip.NewWriter(zipFile)
+	// Lambda only accepts stored or deflated entries, favour a small upload over build time
+	zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, flate.BestCompression)
+	})
+	for _, entry := range sorted {
+		if err := addArchiveEntry(zipWriter, entry); err != nil {
+			return err
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		return err
+	}
+	return zipFile.Close()
+}
+
+// computeArchiveKey hashes the names, modes and contents of the entries of an archive.
+func computeArchiveKey(entries []archiveEntry) (string, error) {
+	sorted := make([]archiveEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	hash := sha256.New()
+	for _, entry := range sorted {
+		fmt.Fprintf(hash, "entry:%s:%o\n", entry.Name, entry.Mode)
+		checksum, err := checksumFile(entry.Source)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "checksum:%s\n", checksum)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func addArchiveEntry(zipWriter *zip.Writer, entry archiveEntry) error {
+	source, err := os.Open(entry.Source)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	header := &zip.FileHeader{
+		Name:     entry.Name,
+		Method:   zip.Deflate,
+		Modified: archiveModified,
+	}
+	header.SetMode(entry.Mode)
+	writer, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, source)
+	return err
+}
+
+// verifyLambdaArchive reads the archive back and checks that the runtime will find an executable bootstrap
+// with the content of the binary.
+func verifyLambdaArchive(archiveFile string, binaryFile string) error {
+	binaryInfo, err := os.Stat(binaryFile)
+	if err != nil {
+		return err
+	}
+	reader, err := zip.OpenReader(archiveFile)
+	if err != nil {
+		return fmt.Errorf("could not read archive %s: %w", archiveFile, err)
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		if file.Name != lambdaBootstrapName {
+			continue
+		}
+		if !file.Mode().IsRegular() || file.Mode().Perm() != lambdaBootstrapMode {
+			return fmt.Errorf("archive %s: %s has mode %s, expected %s", archiveFile, lambdaBootstrapName, file.Mode(), lambdaBootstrapMode)
+		}
+		if file.UncompressedSize64 != uint64(binaryInfo.Size()) {
+			return fmt.Errorf("archive %s: %s has %d bytes, expected %d", archiveFile, lambdaBootstrapName, file.UncompressedSize64, binaryInfo.Size())
+		}
+		// Reading to the end validates the CRC-32 of the entry
+		content, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+		if _, err := io.Copy(io.Discard, content); err != nil {
+			return fmt.Errorf("archive %s: %s is corrupt: %w", archiveFile, lambdaBootstrapName, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("archive %s does not contain %s", archiveFile, lambdaBootstrapName)
+}
@@ -0,0 +1,129 @@
+package adapter
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeSource(t *testing.T, dir string, name string, content string) string {
+	t.Helper()
+	source := filepath.Join(dir, name)
+	if err := os.WriteFile(source, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return source
+}
+
+func TestWriteArchiveIsReproducible(t *testing.T) {
+	dir := t.TempDir()
+	binary := writeSource(t, dir, "binary", "binary content")
+	entries := []archiveEntry{
+		{Name: lambdaBootstrapName, Source: binary, Mode: lambdaBootstrapMode},
+		{Name: "templates/index.html", Source: writeSource(t, dir, "index.html", "<html></html>"), Mode: assetMode},
+		{Name: "ca.pem", Source: writeSource(t, dir, "ca.pem", "certificate"), Mode: assetMode},
+	}
+	reversed := []archiveEntry{entries[2], entries[1], entries[0]}
+
+	first := filepath.Join(dir, "first.zip")
+	if err := writeArchive(first, entries); err != nil {
+		t.Fatal(err)
+	}
+	// A later checkout of the same sources must not change the archive
+	for _, entry := range entries {
+		touched := time.Now().Add(time.Hour)
+		if err := os.Chtimes(entry.Source, touched, touched); err != nil {
+			t.Fatal(err)
+		}
+	}
+	second := filepath.Join(dir, "second.zip")
+	if err := writeArchive(second, reversed); err != nil {
+		t.Fatal(err)
+	}
+
+	firstData, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondData, err := os.ReadFile(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(firstData, secondData) {
+		t.Fatalf("archives of identical entries differ")
+	}
+
+	reader, err := zip.OpenReader(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	wantModes := map[string]os.FileMode{
+		lambdaBootstrapName:    lambdaBootstrapMode,
+		"ca.pem":               assetMode,
+		"templates/index.html": assetMode,
+	}
+	names := make([]string, 0, len(reader.File))
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+		if !file.Modified.Equal(archiveModified) {
+			t.Errorf("%s was modified %s, want %s", file.Name, file.Modified, archiveModified)
+		}
+		if file.Mode() != wantModes[file.Name] {
+			t.Errorf("%s has mode %s, want %s", file.Name, file.Mode(), wantModes[file.Name])
+		}
+	}
+	wantNames := []string{lambdaBootstrapName, "ca.pem", "templates/index.html"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("archive holds %q, want %q", names, wantNames)
+	}
+	if err := verifyLambdaArchive(first, binary); err != nil {
+		t.Errorf("verifyLambdaArchive() = %v", err)
+	}
+}
+
+func TestVerifyLambdaArchive(t *testing.T) {
+	dir := t.TempDir()
+	binary := writeSource(t, dir, "binary", "binary content")
+	other := writeSource(t, dir, "other", "other binary content")
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		wantErr bool
+	}{
+		{
+			name:    "executable bootstrap",
+			entries: []archiveEntry{{Name: lambdaBootstrapName, Source: binary, Mode: lambdaBootstrapMode}},
+		},
+		{
+			name:    "bootstrap not executable",
+			entries: []archiveEntry{{Name: lambdaBootstrapName, Source: binary, Mode: assetMode}},
+			wantErr: true,
+		},
+		{
+			name:    "bootstrap of another binary",
+			entries: []archiveEntry{{Name: lambdaBootstrapName, Source: other, Mode: lambdaBootstrapMode}},
+			wantErr: true,
+		},
+		{
+			name:    "no bootstrap",
+			entries: []archiveEntry{{Name: "main", Source: binary, Mode: lambdaBootstrapMode}},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "bootstrap.zip")
+			if err := writeArchive(archive, test.entries); err != nil {
+				t.Fatal(err)
+			}
+			if err := verifyLambdaArchive(archive, binary); (err != nil) != test.wantErr {
+				t.Errorf("verifyLambdaArchive() error = %v, wantErr %v", err, test.wantErr)
+			}
+		})
+	}
+}
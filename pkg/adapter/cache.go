@@ -0,0 +1,211 @@
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/stefan79/gadget-cli/pkg/adapter/util"
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	BuildCacheEntry struct {
+		Key      string
+		Checksum string
+	}
+
+	// BuildCache remembers which inputs produced the binaries in the staging area.
+	BuildCache struct {
+		Path    string
+		Entries map[string]*BuildCacheEntry
+		mutex   sync.Mutex
+	}
+
+	// listedPackage is the part of go list -json the build key is computed from, standard packages have no module.
+	listedPackage struct {
+		Dir        string
+		EmbedFiles []string
+		Module     *struct {
+			GoMod   string
+			Replace *struct {
+				GoMod string
+			}
+		}
+	}
+)
+
+// listedPackageFields limits go list -json to the fields of listedPackage.
+const listedPackageFields = "-json=Dir,EmbedFiles,Module"
+
+func LoadBuildCache(path string) (*BuildCache, error) {
+	cache := &BuildCache{
+		Path:    path,
+		Entries: make(map[string]*BuildCacheEntry),
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &cache.Entries); err != nil {
+		return nil, fmt.Errorf("could not read build cache %s: %w", path, err)
+	}
+	return cache, nil
+}
+
+// Lookup reports a hit if the target was built from the same key and was not modified since.
+func (c *BuildCache) Lookup(target string, key string) bool {
+	c.mutex.Lock()
+	entry, found := c.Entries[filepath.Base(target)]
+	c.mutex.Unlock()
+	if !found || entry.Key != key {
+		return false
+	}
+	checksum, err := checksumFile(target)
+	if err != nil {
+		return false
+	}
+	return checksum == entry.Checksum
+}
+
+func (c *BuildCache) Store(target string, key string) error {
+	checksum, err := checksumFile(target)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.Entries[filepath.Base(target)] = &BuildCacheEntry{
+		Key:      key,
+		Checksum: checksum,
+	}
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0755); err != nil {
+		return err
+	}
+	return util.SaveYAMLFile(c.Path, c.Entries)
+}
+
+// computeBuildKey hashes everything which influences the output of go build: the sources and embedded files of every
+// package outside of the module cache, which covers the main module and local replacements, their go.mod files,
+// go.sum, the Go version, the environment and the build arguments. The module cache is immutable and pinned by go.sum.
+func computeBuildKey(ctx context.Context, inputSource string, env map[string]string, args []string) (string, error) {
+	hash := sha256.New()
+
+	goEnv, err := queryCommand(ctx, "go", env, "env", "GOVERSION", "GOMODCACHE")
+	if err != nil {
+		return "", err
+	}
+	version, moduleCache, _ := strings.Cut(strings.TrimSpace(string(goEnv)), "\n")
+	fmt.Fprintf(hash, "version:%s\n", version)
+
+	envKeys := make([]string, 0, len(env))
+	for key := range env {
+		envKeys = append(envKeys, key)
+	}
+	sort.Strings(envKeys)
+	for _, key := range envKeys {
+		fmt.Fprintf(hash, "env:%s=%s\n", key, env[key])
+	}
+	fmt.Fprintf(hash, "args:%s\n", strings.Join(args, " "))
+
+	if err := hashFile(hash, "go.sum"); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	packages, err := queryCommand(ctx, "go", env, "list", "-deps", listedPackageFields, inputSource)
+	if err != nil {
+		return "", err
+	}
+	moduleCache = strings.TrimSpace(moduleCache)
+	goModFiles := make(map[string]bool)
+	decoder := json.NewDecoder(bytes.NewReader(packages))
+	for {
+		var listed listedPackage
+		if err := decoder.Decode(&listed); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", fmt.Errorf("could not read the packages of %s: %w", inputSource, err)
+		}
+		if listed.Module == nil || listed.Dir == "" || (moduleCache != "" && isWithin(moduleCache, listed.Dir)) {
+			continue
+		}
+		if err := hashDirectory(hash, listed.Dir); err != nil {
+			return "", err
+		}
+		// Embedded files may live in sub directories, those are not necessarily packages of their own
+		for _, embedded := range listed.EmbedFiles {
+			if err := hashFile(hash, filepath.Join(listed.Dir, embedded)); err != nil {
+				return "", err
+			}
+		}
+		goModFile := listed.Module.GoMod
+		if listed.Module.Replace != nil {
+			goModFile = listed.Module.Replace.GoMod
+		}
+		if goModFile != "" && !goModFiles[goModFile] {
+			goModFiles[goModFile] = true
+			if err := hashFile(hash, goModFile); err != nil {
+				return "", err
+			}
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func isWithin(parent string, dir string) bool {
+	relative, err := filepath.Rel(parent, dir)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// hashDirectory hashes the regular files of a package directory, embedded files of sub directories are hashed apart.
+func hashDirectory(hash io.Writer, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if err := hashFile(hash, filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hashFile(hash io.Writer, fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fmt.Fprintf(hash, "file:%s\n", fileName)
+	_, err = io.Copy(hash, file)
+	return err
+}
+
+func checksumFile(fileName string) (string, error) {
+	hash := sha256.New()
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
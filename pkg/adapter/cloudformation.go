@@ -2,13 +2,18 @@ package adapter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/smithy-go"
+	"github.com/charmbracelet/log"
 )
 
 type (
@@ -16,27 +21,82 @@ type (
 		Found      bool
 		Successful bool
 		Status     string
+		Phase      StackPhase
+		StackId    string
+
+		TerminationProtection bool
+		LastUpdated           *time.Time
+		Outputs               map[string]string
+	}
+
+	StackResource struct {
+		LogicalId    string
+		PhysicalId   string
+		ResourceType string
+		Status       string
+	}
+
+	ResourceChange struct {
+		Action       string
+		LogicalId    string
+		PhysicalId   string
+		ResourceType string
+		Replacement  string
+	}
+
+	ChangeSetPlan struct {
+		StackName     string
+		ChangeSetName string
+		ChangeSetId   string
+		Create        bool
+		Empty         bool
+		Changes       []ResourceChange
 	}
 
 	CloudFormationSDK struct {
-		Client *cloudformation.Client
+		Client       *cloudformation.Client
+		Logger       *log.Logger
+		PollInterval *time.Duration
 	}
 	CloudFormationAdapter interface {
 		DeployTemplateAsBytes(ctx context.Context, name string, data []byte) error
 		DeployTemplateAsFile(ctx context.Context, name string, file string) error
 		UpdateTemplateAsFile(ctx context.Context, name string, file string) error
+		CreateChangeSetAsFile(ctx context.Context, name string, file string, parameters map[string]string, create bool) (*ChangeSetPlan, error)
+		ExecuteChangeSet(ctx context.Context, plan *ChangeSetPlan) error
+		DeleteChangeSet(ctx context.Context, plan *ChangeSetPlan) error
 		GetDeploymentStatus(ctx context.Context, name string) (*DeploymentStatus, error)
+		WaitForStableStatus(ctx context.Context, name string) (*DeploymentStatus, error)
+		DeleteStack(ctx context.Context, name string) error
+		DisableTerminationProtection(ctx context.Context, name string) error
+		ContinueUpdateRollback(ctx context.Context, name string) error
+		CancelUpdate(ctx context.Context, name string) error
 		LoadTemplate(ctx context.Context, name string) ([]byte, error)
+		ListStackResources(ctx context.Context, name string) ([]StackResource, error)
 	}
 )
 
-func NewCloudFormationAdapter() (CloudFormationAdapter, error) {
-	client, err := createCloudFormationClient(context.Background())
+func (r ResourceChange) RequiresReplacement() bool {
+	return r.Replacement == string(types.ReplacementTrue)
+}
+
+func (r ResourceChange) MayRequireReplacement() bool {
+	return r.Replacement == string(types.ReplacementConditional)
+}
+
+func (r ResourceChange) IsIAM() bool {
+	return strings.HasPrefix(r.ResourceType, "AWS::IAM::")
+}
+
+func NewCloudFormationAdapter(ctx context.Context, logger *log.Logger, pollInterval *time.Duration) (CloudFormationAdapter, error) {
+	client, err := createCloudFormationClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 	return &CloudFormationSDK{
-		Client: client,
+		Client:       client,
+		Logger:       logger,
+		PollInterval: pollInterval,
 	}, nil
 }
 
@@ -45,29 +105,145 @@ func (c *CloudFormationSDK) GetDeploymentStatus(ctx context.Context, name string
 		StackName: &name,
 	})
 	if err != nil {
-		if _, ok := err.(*types.StackInstanceNotFoundException); ok {
+		if isStackNotFound(err) {
 			return &DeploymentStatus{
 				Found:      false,
 				Successful: false,
 				Status:     "NotFound",
+				Phase:      StackPhaseNotFound,
 			}, nil
 		} else {
 			return nil, err
 		}
 	}
-	successful := false
-	switch resp.Stacks[0].StackStatus {
-	case types.StackStatusCreateComplete:
-		successful = true
+	if len(resp.Stacks) == 0 {
+		return &DeploymentStatus{
+			Found:      false,
+			Successful: false,
+			Status:     "NotFound",
+			Phase:      StackPhaseNotFound,
+		}, nil
+	}
+	stack := resp.Stacks[0]
+	model := modelStackStatus(stack.StackStatus)
+	lastUpdated := stack.LastUpdatedTime
+	if lastUpdated == nil {
+		lastUpdated = stack.CreationTime
+	}
+	outputs := make(map[string]string, len(stack.Outputs))
+	for _, output := range stack.Outputs {
+		outputs[stringValue(output.OutputKey)] = stringValue(output.OutputValue)
 	}
 	return &DeploymentStatus{
-		Found:      true,
-		Successful: successful,
-		Status:     string(resp.Stacks[0].StackStatus),
+		Found:      model.Phase != StackPhaseNotFound,
+		Successful: model.Successful,
+		Status:     string(stack.StackStatus),
+		Phase:      model.Phase,
+		StackId:    stringValue(stack.StackId),
+
+		TerminationProtection: stack.EnableTerminationProtection != nil && *stack.EnableTerminationProtection,
+		LastUpdated:           lastUpdated,
+		Outputs:               outputs,
 	}, nil
 
 }
 
+// WaitForStableStatus waits until no operation is in progress on the stack.
+func (c *CloudFormationSDK) WaitForStableStatus(ctx context.Context, name string) (*DeploymentStatus, error) {
+	tracker := c.newStackEventTracker(ctx, name)
+	for {
+		if err := c.streamStackEvents(ctx, tracker); err != nil && !isStackNotFound(err) {
+			return nil, err
+		}
+		status, err := c.GetDeploymentStatus(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if status.Phase != StackPhaseInProgress {
+			return status, nil
+		}
+		if err := sleep(ctx, *c.PollInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *CloudFormationSDK) DeleteStack(ctx context.Context, name string) error {
+	status, err := c.GetDeploymentStatus(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !status.Found {
+		return nil
+	}
+	// Deleted stacks can only be described by their id
+	tracker := c.newStackEventTracker(ctx, status.StackId)
+	tracker.StackName = name
+	_, err = c.Client.DeleteStack(ctx, &cloudformation.DeleteStackInput{
+		StackName: &name,
+	})
+	if err != nil {
+		return err
+	}
+	return c.waitForStack(ctx, tracker, "deletion", types.StackStatusDeleteComplete,
+		types.StackStatusDeleteInProgress,
+	)
+}
+
+func (c *CloudFormationSDK) DisableTerminationProtection(ctx context.Context, name string) error {
+	enabled := false
+	_, err := c.Client.UpdateTerminationProtection(ctx, &cloudformation.UpdateTerminationProtectionInput{
+		StackName:                   &name,
+		EnableTerminationProtection: &enabled,
+	})
+	return err
+}
+
+// CancelUpdate asks CloudFormation to roll back a running update, it does not wait for the rollback to finish.
+func (c *CloudFormationSDK) CancelUpdate(ctx context.Context, name string) error {
+	_, err := c.Client.CancelUpdateStack(ctx, &cloudformation.CancelUpdateStackInput{
+		StackName: &name,
+	})
+	return err
+}
+
+func (c *CloudFormationSDK) ContinueUpdateRollback(ctx context.Context, name string) error {
+	tracker := c.newStackEventTracker(ctx, name)
+	_, err := c.Client.ContinueUpdateRollback(ctx, &cloudformation.ContinueUpdateRollbackInput{
+		StackName: &name,
+	})
+	if err != nil {
+		return err
+	}
+	return c.waitForStack(ctx, tracker, "update rollback", types.StackStatusUpdateRollbackComplete,
+		types.StackStatusUpdateRollbackInProgress,
+		types.StackStatusUpdateRollbackCompleteCleanupInProgress,
+	)
+}
+
+// noChangesReasons are reported by CloudFormation when a template does not differ from the deployed one.
+var noChangesReasons = []string{
+	"No updates are to be performed",
+	"didn't contain changes",
+}
+
+func isNoChangesReason(reason string) bool {
+	for _, candidate := range noChangesReasons {
+		if strings.Contains(reason, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+func isStackNotFound(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode() == "ValidationError" && strings.Contains(apiErr.ErrorMessage(), "does not exist")
+	}
+	return false
+}
+
 func (c *CloudFormationSDK) LoadTemplate(ctx context.Context, name string) ([]byte, error) {
 	resp, err := c.Client.GetTemplate(ctx, &cloudformation.GetTemplateInput{
 		StackName: &name,
@@ -78,6 +254,28 @@ func (c *CloudFormationSDK) LoadTemplate(ctx context.Context, name string) ([]by
 	return []byte(*resp.TemplateBody), nil
 }
 
+func (c *CloudFormationSDK) ListStackResources(ctx context.Context, name string) ([]StackResource, error) {
+	resources := make([]StackResource, 0)
+	paginator := cloudformation.NewListStackResourcesPaginator(c.Client, &cloudformation.ListStackResourcesInput{
+		StackName: &name,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, summary := range page.StackResourceSummaries {
+			resources = append(resources, StackResource{
+				LogicalId:    stringValue(summary.LogicalResourceId),
+				PhysicalId:   stringValue(summary.PhysicalResourceId),
+				ResourceType: stringValue(summary.ResourceType),
+				Status:       string(summary.ResourceStatus),
+			})
+		}
+	}
+	return resources, nil
+}
+
 func (c *CloudFormationSDK) DeployTemplateAsFile(ctx context.Context, name string, file string) error {
 	data, err := os.ReadFile(file)
 	if err != nil {
@@ -97,6 +295,7 @@ func (c *CloudFormationSDK) UpdateTemplateAsFile(ctx context.Context, name strin
 
 func (c *CloudFormationSDK) DeployTemplateAsBytes(ctx context.Context, name string, data []byte) error {
 	bodyAsString := string(data)
+	tracker := c.newStackEventTracker(ctx, name)
 	_, err := c.Client.CreateStack(ctx, &cloudformation.CreateStackInput{
 		StackName:    &name,
 		TemplateBody: &bodyAsString,
@@ -108,47 +307,197 @@ func (c *CloudFormationSDK) DeployTemplateAsBytes(ctx context.Context, name stri
 		return err
 	}
 
+	return c.waitForStack(ctx, tracker, "creation", types.StackStatusCreateComplete,
+		types.StackStatusCreateInProgress,
+		types.StackStatusUpdateCompleteCleanupInProgress,
+	)
+}
+
+func (c *CloudFormationSDK) UpdateTemplateAsBytes(ctx context.Context, name string, data []byte) error {
+	bodyAsString := string(data)
+	tracker := c.newStackEventTracker(ctx, name)
+	_, err := c.Client.UpdateStack(ctx, &cloudformation.UpdateStackInput{
+		StackName:    &name,
+		TemplateBody: &bodyAsString,
+		Capabilities: []types.Capability{
+			types.CapabilityCapabilityIam,
+		},
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && isNoChangesReason(apiErr.ErrorMessage()) {
+			c.Logger.Info("Stack is up to date", "stack", name)
+			return nil
+		}
+		return err
+	}
+
+	return c.waitForStack(ctx, tracker, "update", types.StackStatusUpdateComplete,
+		types.StackStatusUpdateInProgress,
+		types.StackStatusUpdateCompleteCleanupInProgress,
+	)
+}
+
+func (c *CloudFormationSDK) CreateChangeSetAsFile(ctx context.Context, name string, file string, parameters map[string]string, create bool) (*ChangeSetPlan, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return c.CreateChangeSetAsBytes(ctx, name, data, parameters, create)
+}
+
+func (c *CloudFormationSDK) CreateChangeSetAsBytes(ctx context.Context, name string, data []byte, parameters map[string]string, create bool) (*ChangeSetPlan, error) {
+	bodyAsString := string(data)
+	changeSetName := fmt.Sprintf("gadget-%d", time.Now().Unix())
+	changeSetType := types.ChangeSetTypeUpdate
+	if create {
+		changeSetType = types.ChangeSetTypeCreate
+	}
+	resp, err := c.Client.CreateChangeSet(ctx, &cloudformation.CreateChangeSetInput{
+		StackName:     &name,
+		ChangeSetName: &changeSetName,
+		ChangeSetType: changeSetType,
+		TemplateBody:  &bodyAsString,
+		Parameters:    stackParameters(parameters),
+		Capabilities: []types.Capability{
+			types.CapabilityCapabilityIam,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	plan := &ChangeSetPlan{
+		StackName:     name,
+		ChangeSetName: changeSetName,
+		ChangeSetId:   *resp.Id,
+		Create:        create,
+		Changes:       make([]ResourceChange, 0),
+	}
+
 	for {
-		resp, err := c.Client.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{
-			StackName: &name,
+		desc, err := c.Client.DescribeChangeSet(ctx, &cloudformation.DescribeChangeSetInput{
+			StackName:     &name,
+			ChangeSetName: resp.Id,
 		})
 		if err != nil {
-			return err
+			return nil, err
 		}
-
-		if len(resp.Stacks) == 0 {
-			return fmt.Errorf("stack %s not found", name)
+		switch desc.Status {
+		case types.ChangeSetStatusCreateComplete:
+			return plan, c.collectChanges(ctx, plan, desc)
+		case types.ChangeSetStatusCreatePending, types.ChangeSetStatusCreateInProgress:
+			if err := sleep(ctx, 2*time.Second); err != nil {
+				return nil, err
+			}
+		default:
+			reason := ""
+			if desc.StatusReason != nil {
+				reason = *desc.StatusReason
+			}
+			if desc.Status == types.ChangeSetStatusFailed && isNoChangesReason(reason) {
+				plan.Empty = true
+				return plan, nil
+			}
+			return nil, fmt.Errorf("change set %s for stack %s failed with status %s: %s", changeSetName, name, desc.Status, reason)
 		}
+	}
+}
 
-		switch resp.Stacks[0].StackStatus {
-		case types.StackStatusCreateComplete:
+func (c *CloudFormationSDK) collectChanges(ctx context.Context, plan *ChangeSetPlan, desc *cloudformation.DescribeChangeSetOutput) error {
+	for {
+		for _, change := range desc.Changes {
+			if change.ResourceChange == nil {
+				continue
+			}
+			plan.Changes = append(plan.Changes, ResourceChange{
+				Action:       string(change.ResourceChange.Action),
+				LogicalId:    stringValue(change.ResourceChange.LogicalResourceId),
+				PhysicalId:   stringValue(change.ResourceChange.PhysicalResourceId),
+				ResourceType: stringValue(change.ResourceChange.ResourceType),
+				Replacement:  string(change.ResourceChange.Replacement),
+			})
+		}
+		if desc.NextToken == nil {
 			return nil
-		case types.StackStatusUpdateCompleteCleanupInProgress:
-			time.Sleep(10 * time.Second)
-		case types.StackStatusCreateInProgress:
-			time.Sleep(10 * time.Second)
-		default:
-			return fmt.Errorf("creation of stack %s failed with status %s", name, resp.Stacks[0].StackStatus)
+		}
+		var err error
+		desc, err = c.Client.DescribeChangeSet(ctx, &cloudformation.DescribeChangeSetInput{
+			StackName:     &plan.StackName,
+			ChangeSetName: &plan.ChangeSetId,
+			NextToken:     desc.NextToken,
+		})
+		if err != nil {
+			return err
 		}
 	}
 }
 
-func (c *CloudFormationSDK) UpdateTemplateAsBytes(ctx context.Context, name string, data []byte) error {
-	bodyAsString := string(data)
-	_, err := c.Client.UpdateStack(ctx, &cloudformation.UpdateStackInput{
-		StackName:    &name,
-		TemplateBody: &bodyAsString,
-		Capabilities: []types.Capability{
-			types.CapabilityCapabilityIam,
-		},
+func (c *CloudFormationSDK) ExecuteChangeSet(ctx context.Context, plan *ChangeSetPlan) error {
+	tracker := c.newStackEventTracker(ctx, plan.StackName)
+	_, err := c.Client.ExecuteChangeSet(ctx, &cloudformation.ExecuteChangeSetInput{
+		StackName:     &plan.StackName,
+		ChangeSetName: &plan.ChangeSetId,
 	})
 	if err != nil {
 		return err
 	}
 
+	// The stack status only changes once CloudFormation picked up the change set,
+	// wait for that to not mistake the previous status for the result.
 	for {
+		desc, err := c.Client.DescribeChangeSet(ctx, &cloudformation.DescribeChangeSetInput{
+			StackName:     &plan.StackName,
+			ChangeSetName: &plan.ChangeSetId,
+		})
+		if err != nil {
+			return err
+		}
+		if desc.ExecutionStatus != types.ExecutionStatusAvailable {
+			break
+		}
+		if err := sleep(ctx, 2*time.Second); err != nil {
+			return err
+		}
+	}
+
+	if plan.Create {
+		return c.waitForStack(ctx, tracker, "creation", types.StackStatusCreateComplete,
+			types.StackStatusReviewInProgress,
+			types.StackStatusCreateInProgress,
+		)
+	}
+	return c.waitForStack(ctx, tracker, "update", types.StackStatusUpdateComplete,
+		types.StackStatusUpdateInProgress,
+		types.StackStatusUpdateCompleteCleanupInProgress,
+	)
+}
+
+func (c *CloudFormationSDK) DeleteChangeSet(ctx context.Context, plan *ChangeSetPlan) error {
+	_, err := c.Client.DeleteChangeSet(ctx, &cloudformation.DeleteChangeSetInput{
+		StackName:     &plan.StackName,
+		ChangeSetName: &plan.ChangeSetId,
+	})
+	if err != nil {
+		return err
+	}
+	if plan.Create {
+		// A change set of type CREATE leaves an empty stack in REVIEW_IN_PROGRESS behind
+		_, err = c.Client.DeleteStack(ctx, &cloudformation.DeleteStackInput{
+			StackName: &plan.StackName,
+		})
+	}
+	return err
+}
+
+func (c *CloudFormationSDK) waitForStack(ctx context.Context, tracker *stackEventTracker, operation string, target types.StackStatus, pending ...types.StackStatus) error {
+	name := tracker.StackName
+	for {
+		err := c.streamStackEvents(ctx, tracker)
+		if err != nil {
+			return err
+		}
 		resp, err := c.Client.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{
-			StackName: &name,
+			StackName: &tracker.Reference,
 		})
 		if err != nil {
 			return err
@@ -158,19 +507,58 @@ func (c *CloudFormationSDK) UpdateTemplateAsBytes(ctx context.Context, name stri
 			return fmt.Errorf("stack %s not found", name)
 		}
 
-		switch resp.Stacks[0].StackStatus {
-		case types.StackStatusUpdateComplete:
+		status := resp.Stacks[0].StackStatus
+		if status == target {
+			tracker.summarize(c.Logger)
 			return nil
-		case types.StackStatusUpdateInProgress:
-			time.Sleep(10 * time.Second)
-		case types.StackStatusUpdateCompleteCleanupInProgress:
-			time.Sleep(10 * time.Second)
-		default:
-			return fmt.Errorf("update of stack %s failed with status %s", name, resp.Stacks[0].StackStatus)
+		}
+		if !containsStatus(pending, status) {
+			// Pick up the events that led to the final status
+			if err := c.streamStackEvents(ctx, tracker); err != nil {
+				return err
+			}
+			tracker.summarize(c.Logger)
+			return &StackOperationError{
+				StackName: name,
+				Operation: operation,
+				Status:    string(status),
+				Failures:  tracker.failures(),
+			}
+		}
+		if err := sleep(ctx, *c.PollInterval); err != nil {
+			return err
 		}
 	}
 }
 
+// sleep waits for the given duration unless the context is done first.
+func sleep(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func containsStatus(statuses []types.StackStatus, status types.StackStatus) bool {
+	for _, candidate := range statuses {
+		if candidate == status {
+			return true
+		}
+	}
+	return false
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
 func createCloudFormationClient(ctx context.Context) (*cloudformation.Client, error) {
 	cfg, err := config.LoadDefaultConfig(ctx)
 	if err != nil {
@@ -180,3 +568,21 @@ func createCloudFormationClient(ctx context.Context) (*cloudformation.Client, er
 	client := cloudformation.NewFromConfig(cfg)
 	return client, nil
 }
+
+// stackParameters passes the parameter values of gadget.yaml, sorted so the change set request is stable.
+func stackParameters(parameters map[string]string) []types.Parameter {
+	keys := make([]string, 0, len(parameters))
+	for key := range parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]types.Parameter, 0, len(keys))
+	for _, key := range keys {
+		key, value := key, parameters[key]
+		result = append(result, types.Parameter{
+			ParameterKey:   &key,
+			ParameterValue: &value,
+		})
+	}
+	return result
+}
@@ -0,0 +1,177 @@
+package adapter
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/charmbracelet/log"
+)
+
+type (
+	resourceTiming struct {
+		LogicalId    string
+		ResourceType string
+		Status       string
+		Started      time.Time
+		Finished     time.Time
+	}
+
+	stackEventTracker struct {
+		StackName string
+		Reference string
+		Started   time.Time
+		Seen      map[string]bool
+		Events    []types.StackEvent
+		Timings   map[string]*resourceTiming
+	}
+)
+
+// newStackEventTracker remembers the events already present on the stack, so only
+// events caused by the upcoming operation are streamed.
+func (c *CloudFormationSDK) newStackEventTracker(ctx context.Context, name string) *stackEventTracker {
+	tracker := &stackEventTracker{
+		StackName: name,
+		Reference: name,
+		Started:   time.Now(),
+		Seen:      make(map[string]bool),
+		Events:    make([]types.StackEvent, 0),
+		Timings:   make(map[string]*resourceTiming),
+	}
+	resp, err := c.Client.DescribeStackEvents(ctx, &cloudformation.DescribeStackEventsInput{
+		StackName: &name,
+	})
+	if err != nil {
+		// The stack does not exist yet, every event will be new
+		return tracker
+	}
+	for _, event := range resp.StackEvents {
+		tracker.Seen[stringValue(event.EventId)] = true
+	}
+	return tracker
+}
+
+func (c *CloudFormationSDK) streamStackEvents(ctx context.Context, tracker *stackEventTracker) error {
+	newEvents := make([]types.StackEvent, 0)
+	var nextToken *string
+	for {
+		resp, err := c.Client.DescribeStackEvents(ctx, &cloudformation.DescribeStackEventsInput{
+			StackName: &tracker.Reference,
+			NextToken: nextToken,
+		})
+		if err != nil {
+			return err
+		}
+		reachedSeen := false
+		for _, event := range resp.StackEvents {
+			if tracker.Seen[stringValue(event.EventId)] {
+				reachedSeen = true
+				break
+			}
+			newEvents = append(newEvents, event)
+		}
+		if reachedSeen || resp.NextToken == nil {
+			break
+		}
+		nextToken = resp.NextToken
+	}
+
+	// DescribeStackEvents returns the newest event first
+	for i := len(newEvents) - 1; i >= 0; i-- {
+		event := newEvents[i]
+		tracker.Seen[stringValue(event.EventId)] = true
+		tracker.Events = append(tracker.Events, event)
+		tracker.record(event)
+		logStackEvent(c.Logger, event)
+	}
+	return nil
+}
+
+func (t *stackEventTracker) record(event types.StackEvent) {
+	logicalId := stringValue(event.LogicalResourceId)
+	if logicalId == t.StackName {
+		return
+	}
+	timing, found := t.Timings[logicalId]
+	if !found {
+		timing = &resourceTiming{
+			LogicalId:    logicalId,
+			ResourceType: stringValue(event.ResourceType),
+		}
+		t.Timings[logicalId] = timing
+	}
+	status := string(event.ResourceStatus)
+	timing.Status = status
+	if event.Timestamp == nil {
+		return
+	}
+	if strings.HasSuffix(status, "_IN_PROGRESS") && timing.Started.IsZero() {
+		timing.Started = *event.Timestamp
+	} else if !strings.HasSuffix(status, "_IN_PROGRESS") {
+		timing.Finished = *event.Timestamp
+	}
+}
+
+func (t *stackEventTracker) summarize(logger *log.Logger) {
+	timings := make([]*resourceTiming, 0, len(t.Timings))
+	for _, timing := range t.Timings {
+		timings = append(timings, timing)
+	}
+	sort.Slice(timings, func(i, j int) bool {
+		if timings[i].elapsed() == timings[j].elapsed() {
+			return timings[i].LogicalId < timings[j].LogicalId
+		}
+		return timings[i].elapsed() > timings[j].elapsed()
+	})
+	for _, timing := range timings {
+		logger.Info("Resource summary",
+			"resource", timing.LogicalId,
+			"type", timing.ResourceType,
+			"status", timing.Status,
+			"elapsed", timing.elapsed(),
+		)
+	}
+	logger.Info("Stack operation finished", "stack", t.StackName, "elapsed", time.Since(t.Started).Round(time.Second))
+}
+
+func (r *resourceTiming) elapsed() time.Duration {
+	if r.Started.IsZero() || r.Finished.IsZero() {
+		return 0
+	}
+	return r.Finished.Sub(r.Started).Round(time.Second)
+}
+
+func logStackEvent(logger *log.Logger, event types.StackEvent) {
+	keyvals := []interface{}{
+		"resource", stringValue(event.LogicalResourceId),
+		"type", stringValue(event.ResourceType),
+		"status", event.ResourceStatus,
+	}
+	if event.ResourceStatusReason != nil {
+		keyvals = append(keyvals, "reason", *event.ResourceStatusReason)
+	}
+	if strings.HasSuffix(string(event.ResourceStatus), "_FAILED") {
+		logger.Error("Stack event", keyvals...)
+		return
+	}
+	logger.Info("Stack event", keyvals...)
+}
+
+func (t *stackEventTracker) failures() []StackFailure {
+	failures := make([]StackFailure, 0)
+	for _, event := range t.Events {
+		if !strings.HasSuffix(string(event.ResourceStatus), "_FAILED") {
+			continue
+		}
+		failures = append(failures, StackFailure{
+			LogicalId:    stringValue(event.LogicalResourceId),
+			ResourceType: stringValue(event.ResourceType),
+			Status:       string(event.ResourceStatus),
+			Reason:       stringValue(event.ResourceStatusReason),
+		})
+	}
+	return failures
+}
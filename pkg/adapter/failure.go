@@ -0,0 +1,74 @@
+package adapter
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	StackFailure struct {
+		LogicalId    string
+		ResourceType string
+		Status       string
+		Reason       string
+		Command      string
+	}
+
+	StackOperationError struct {
+		StackName string
+		Operation string
+		Status    string
+		Failures  []StackFailure
+	}
+)
+
+// cancellationReasons mark resources that only failed because another resource failed first.
+var cancellationReasons = []string{
+	"Resource creation cancelled",
+	"Resource update cancelled",
+}
+
+func (f StackFailure) IsRootCause() bool {
+	for _, reason := range cancellationReasons {
+		if strings.HasPrefix(f.Reason, reason) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *StackOperationError) Error() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%s of stack %s failed with status %s", e.Operation, e.StackName, e.Status)
+	for _, failure := range e.RootCauses() {
+		builder.WriteString("\n  - ")
+		if failure.Command != "" {
+			fmt.Fprintf(&builder, "[%s] ", failure.Command)
+		}
+		fmt.Fprintf(&builder, "%s (%s) %s: %s", failure.LogicalId, failure.ResourceType, failure.Status, failure.Reason)
+	}
+	return builder.String()
+}
+
+// RootCauses returns the failures which were not caused by the cancellation of a sibling resource.
+func (e *StackOperationError) RootCauses() []StackFailure {
+	causes := make([]StackFailure, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		if failure.IsRootCause() {
+			causes = append(causes, failure)
+		}
+	}
+	if len(causes) == 0 {
+		return e.Failures
+	}
+	return causes
+}
+
+// AttributeCommands maps each failing resource to the gadget command which contributed it.
+func (e *StackOperationError) AttributeCommands(owners map[string]string) {
+	for i := range e.Failures {
+		if command, found := owners[e.Failures[i].LogicalId]; found {
+			e.Failures[i].Command = command
+		}
+	}
+}
@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
 
 	"github.com/stefan79/gadget-cli/pkg/adapter/util"
 	"golang.org/x/mod/modfile"
+	"gopkg.in/yaml.v2"
 )
 
 type (
@@ -16,10 +20,37 @@ type (
 		Tags            map[string]string
 		Template        *Template
 		StagingArea     *string
+		Contributors    map[string]string
 	}
 	GadgetoFormationAdapter interface {
-		MergeCommandTemplate(command *string, source *string, fileName *string) error
+		MergeCommandTemplate(command *string, source *string, fileName *string, options *CommandTemplateOptions) error
+		AddLayerVersion(options *LayerTemplateOptions) error
 		SaveApplicationTemplate(fileName *string) error
+		ResourceOwners() map[string]string
+		// CheckParameters names the parameter values the merged template does not declare and the declared
+		// parameters without default which have no value.
+		CheckParameters(values map[string]string) error
+	}
+
+	CommandTemplateOptions struct {
+		Architecture string
+		ImageUri     string
+		// Layers are the names of the layers referenced by the functions of the command
+		Layers []string
+	}
+
+	LayerTemplateOptions struct {
+		Name          string
+		Bucket        string
+		Key           string
+		Architectures []string
+	}
+
+	DeployedFunction struct {
+		LogicalId string
+		Command   string
+		S3Key     string
+		ImageUri  string
 	}
 
 	Template struct {
@@ -36,6 +67,8 @@ type (
 	}
 )
 
+const commandAliasTag = "org.gadget.source.command.alias"
+
 func createEmptyCloudformationTemplate() *Template {
 	return &Template{
 		AWSTemplateFormatVersion: "2010-09-09",
@@ -48,8 +81,9 @@ func createEmptyCloudformationTemplate() *Template {
 	}
 }
 
-func (t *Template) applyToSelectiveResourceTypes(resourceTypePredicate util.ValuePredicate, applicator util.Applicator) error {
-	for resourceKey, resourceRaw := range t.Resources {
+func (t *Template) applyToSelectiveResourceTypes(resourceKeys []string, resourceTypePredicate util.ValuePredicate, applicator util.Applicator) error {
+	for _, resourceKey := range resourceKeys {
+		resourceRaw := t.Resources[resourceKey]
 		if resource, ok := resourceRaw.(map[interface{}]interface{}); ok {
 			if resourceTypeRaw, found := resource["Type"]; found {
 				if resourceType, ok := resourceTypeRaw.(string); ok {
@@ -115,28 +149,177 @@ func NewGadgetoFormationAdapter(applicationName *string, tags map[string]string,
 		Tags:            tags,
 		Template:        createEmptyCloudformationTemplate(),
 		StagingArea:     stagingArea,
+		Contributors:    make(map[string]string),
 	}, nil
 }
 
-func (g *GadgetoFormationCustom) MergeCommandTemplate(command *string, source *string, fileName *string) error {
+func (g *GadgetoFormationCustom) MergeCommandTemplate(command *string, source *string, fileName *string, options *CommandTemplateOptions) error {
 	sourceMap, err := util.ReadYAMLFile(*fileName)
 	if err != nil {
 		return fmt.Errorf("could not read source file %s: %w", *fileName, err)
 	}
-	if err := g.Template.mergeElements(sourceMap.(map[interface{}]interface{})); err != nil {
+	sourceTemplate, ok := sourceMap.(map[interface{}]interface{})
+	if !ok {
+		return fmt.Errorf("could not process source file %s due to incompatible type %T", *fileName, sourceMap)
+	}
+	commandResources := resourceKeysOf(sourceTemplate)
+	if err := g.Template.mergeElements(sourceTemplate); err != nil {
 		return err
 	}
-	commandTags := g.Tags
-	commandTags["org.gadget.source.command.alias"] = *command
+	for _, resourceKey := range commandResources {
+		g.Contributors[resourceKey] = *command
+	}
+	commandTags := make(map[string]string, len(g.Tags)+2)
+	for key, value := range g.Tags {
+		commandTags[key] = value
+	}
+	commandTags[commandAliasTag] = *command
 	commandTags["org.gadget.source.command.source"] = *source
 
 	applicationTagsApplicator := util.GenerateTagApplicator(g.Tags)
 	commandTagsApplicator := util.GenerateTagApplicator(commandTags)
 
-	commandTagsErr := g.Template.applyToSelectiveResourceTypes(util.WhiteListCommandSpecificResourceTypes, commandTagsApplicator)
-	applicationTagsErr := g.Template.applyToSelectiveResourceTypes(util.BlackListCommandSpecificResourceTypes, applicationTagsApplicator)
+	// Only tag the resources contributed by this command, previously merged ones are already tagged
+	commandTagsErr := g.Template.applyToSelectiveResourceTypes(commandResources, util.WhiteListCommandSpecificResourceTypes, commandTagsApplicator)
+	applicationTagsErr := g.Template.applyToSelectiveResourceTypes(commandResources, util.BlackListCommandSpecificResourceTypes, applicationTagsApplicator)
+
+	var architectureErr error
+	if options != nil && options.Architecture != "" {
+		architectureErr = g.Template.applyToSelectiveResourceTypes(commandResources, util.LambdaFunctionResourceType, util.GenerateArchitectureApplicator(options.Architecture))
+	}
+
+	var imageErr error
+	if options != nil && options.ImageUri != "" {
+		imageErr = g.Template.applyToSelectiveResourceTypes(commandResources, util.LambdaFunctionResourceType, util.GenerateImageApplicator(options.ImageUri))
+	}
+
+	var layersErr error
+	if options != nil && len(options.Layers) > 0 {
+		layerRefs := make([]interface{}, 0, len(options.Layers))
+		for _, layer := range options.Layers {
+			layerId := LayerLogicalId(layer)
+			if _, found := g.Template.Resources[layerId]; !found {
+				layersErr = errors.Join(layersErr, fmt.Errorf("layer %s has not been added to the template", layer))
+				continue
+			}
+			layerRefs = append(layerRefs, map[interface{}]interface{}{"Ref": layerId})
+		}
+		layersErr = errors.Join(layersErr, g.Template.applyToSelectiveResourceTypes(commandResources, util.LambdaFunctionResourceType, util.GenerateLayerApplicator(layerRefs)))
+	}
+
+	return errors.Join(commandTagsErr, applicationTagsErr, architectureErr, imageErr, layersErr)
+}
+
+// AddLayerVersion adds a shared layer to the application template, commands reference it by its logical id.
+func (g *GadgetoFormationCustom) AddLayerVersion(options *LayerTemplateOptions) error {
+	layerId := LayerLogicalId(options.Name)
+	if _, found := g.Template.Resources[layerId]; found {
+		return fmt.Errorf("resource %s of layer %s already exists", layerId, options.Name)
+	}
+	properties := map[interface{}]interface{}{
+		"LayerName":   fmt.Sprintf("%s-%s", *g.ApplicationName, options.Name),
+		"Description": fmt.Sprintf("Layer %s of application %s", options.Name, *g.ApplicationName),
+		"Content": map[interface{}]interface{}{
+			"S3Bucket": options.Bucket,
+			"S3Key":    options.Key,
+		},
+	}
+	if len(options.Architectures) > 0 {
+		architectures := make([]interface{}, 0, len(options.Architectures))
+		for _, architecture := range options.Architectures {
+			architectures = append(architectures, architecture)
+		}
+		properties["CompatibleArchitectures"] = architectures
+	}
+	g.Template.Resources[layerId] = map[interface{}]interface{}{
+		"Type":       "AWS::Lambda::LayerVersion",
+		"Properties": properties,
+	}
+	return nil
+}
+
+// LayerLogicalId derives the logical id of a layer from its name, e.g. ca-bundle becomes CaBundleLayer.
+func LayerLogicalId(name string) string {
+	var logicalId strings.Builder
+	upper := true
+	for _, char := range name {
+		if char > unicode.MaxASCII || (!unicode.IsLetter(char) && !unicode.IsDigit(char)) {
+			upper = true
+			continue
+		}
+		if upper {
+			char = unicode.ToUpper(char)
+			upper = false
+		}
+		logicalId.WriteRune(char)
+	}
+	logicalId.WriteString("Layer")
+	return logicalId.String()
+}
+
+// ResourceOwners maps the logical id of every merged resource to the alias of the command which contributed it.
+// The command alias tag takes precedence, resources which cannot carry tags fall back to the merge order.
+func (g *GadgetoFormationCustom) ResourceOwners() map[string]string {
+	owners := make(map[string]string)
+	for resourceKey, command := range g.Contributors {
+		owners[resourceKey] = command
+	}
+	for resourceKey, resourceRaw := range g.Template.Resources {
+		if resource, ok := resourceRaw.(map[interface{}]interface{}); ok {
+			if command, found := util.ReadTag(resource, commandAliasTag); found {
+				owners[resourceKey] = command
+			}
+		}
+	}
+	return owners
+}
+
+func resourceKeysOf(source map[interface{}]interface{}) []string {
+	keys := make([]string, 0)
+	if resources, ok := source["Resources"].(map[interface{}]interface{}); ok {
+		for keyRaw := range resources {
+			if key, ok := keyRaw.(string); ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
 
-	return errors.Join(commandTagsErr, applicationTagsErr)
+func (g *GadgetoFormationCustom) CheckParameters(values map[string]string) error {
+	unknown := make([]string, 0)
+	for name := range values {
+		if _, declared := g.Template.Parameters[name]; !declared {
+			unknown = append(unknown, name)
+		}
+	}
+	missing := make([]string, 0)
+	for name, parameter := range g.Template.Parameters {
+		properties, _ := parameter.(map[interface{}]interface{})
+		if _, hasDefault := properties["Default"]; !hasDefault {
+			if _, found := values[name]; !found {
+				missing = append(missing, name)
+			}
+		}
+	}
+	sort.Strings(unknown)
+	sort.Strings(missing)
+	errs := make([]error, 0)
+	if len(unknown) > 0 {
+		declared := make([]string, 0, len(g.Template.Parameters))
+		for name := range g.Template.Parameters {
+			declared = append(declared, name)
+		}
+		sort.Strings(declared)
+		if len(declared) == 0 {
+			declared = append(declared, "none")
+		}
+		errs = append(errs, fmt.Errorf("the template does not declare the parameters %s, it declares %s", strings.Join(unknown, ", "), strings.Join(declared, ", ")))
+	}
+	if len(missing) > 0 {
+		errs = append(errs, fmt.Errorf("the parameters %s have no default and need a value in gadget.yaml", strings.Join(missing, ", ")))
+	}
+	return errors.Join(errs...)
 }
 
 func (g *GadgetoFormationCustom) SaveApplicationTemplate(fileName *string) error {
@@ -144,6 +327,40 @@ func (g *GadgetoFormationCustom) SaveApplicationTemplate(fileName *string) error
 	return util.SaveYAMLFile(fullFileName, g.Template)
 }
 
+// ReadDeployedFunctions lists the Lambda functions of a deployed application template together with their command.
+func ReadDeployedFunctions(data []byte) ([]DeployedFunction, error) {
+	var template Template
+	if err := yaml.Unmarshal(data, &template); err != nil {
+		return nil, err
+	}
+	functions := make([]DeployedFunction, 0)
+	for resourceKey, resourceRaw := range template.Resources {
+		resource, ok := resourceRaw.(map[interface{}]interface{})
+		if !ok || resource["Type"] != "AWS::Lambda::Function" {
+			continue
+		}
+		command, found := util.ReadTag(resource, commandAliasTag)
+		if !found {
+			continue
+		}
+		function := DeployedFunction{
+			LogicalId: resourceKey,
+			Command:   command,
+		}
+		if properties, ok := resource["Properties"].(map[interface{}]interface{}); ok {
+			if code, ok := properties["Code"].(map[interface{}]interface{}); ok {
+				function.S3Key, _ = code["S3Key"].(string)
+				function.ImageUri, _ = code["ImageUri"].(string)
+			}
+		}
+		functions = append(functions, function)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		return functions[i].LogicalId < functions[j].LogicalId
+	})
+	return functions, nil
+}
+
 func readGoModule() (string, error) {
 	data, err := os.ReadFile("go.mod")
 	if err != nil {
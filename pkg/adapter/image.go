@@ -0,0 +1,519 @@
+package adapter
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	ociIndexMediaType        = "application/vnd.oci.image.index.v1+json"
+	ociManifestMediaType     = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigMediaType       = "application/vnd.oci.image.config.v1+json"
+	ociLayerMediaType        = "application/vnd.oci.image.layer.v1.tar+gzip"
+	dockerManifestListType   = "application/vnd.docker.distribution.manifest.list.v2+json"
+	ociRefNameAnnotation     = "org.opencontainers.image.ref.name"
+	ociLayoutFile            = "oci-layout"
+	ociIndexFile             = "index.json"
+	ociLayoutContent         = `{"imageLayoutVersion":"1.0.0"}`
+	lambdaTaskRoot           = "var/task"
+	maxBufferedImageBlobSize = 4 << 20
+)
+
+type (
+	// ImageOptions control how the container image of a command is assembled.
+	ImageOptions struct {
+		// BaseImage is an OCI image layout tarball the command is layered onto, empty starts from scratch
+		BaseImage string
+		// Architecture is the GOARCH of the binary
+		Architecture string
+		// Tag names the image inside of the layout
+		Tag string
+	}
+
+	ociDescriptor struct {
+		MediaType   string            `json:"mediaType"`
+		Digest      string            `json:"digest"`
+		Size        int64             `json:"size"`
+		Platform    *ociPlatform      `json:"platform,omitempty"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+	}
+
+	ociPlatform struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	}
+
+	ociIndex struct {
+		SchemaVersion int             `json:"schemaVersion"`
+		MediaType     string          `json:"mediaType,omitempty"`
+		Manifests     []ociDescriptor `json:"manifests"`
+	}
+
+	ociManifest struct {
+		SchemaVersion int             `json:"schemaVersion"`
+		MediaType     string          `json:"mediaType,omitempty"`
+		Config        ociDescriptor   `json:"config"`
+		Layers        []ociDescriptor `json:"layers"`
+	}
+
+	// imageLayout is the content of an OCI image layout tarball, only small blobs are kept in memory.
+	imageLayout struct {
+		Path  string
+		Index *ociIndex
+		Blobs map[string][]byte
+	}
+
+	imageBlob struct {
+		Descriptor ociDescriptor
+		// Content holds small blobs, large ones are streamed from File
+		Content []byte
+		File    string
+	}
+)
+
+// Image writes the container image of a command as OCI image layout tarball and returns the digest of its manifest.
+// The binary becomes the entrypoint, it is placed with the assets in the Lambda task root like in a zip archive.
+func (a *DefaultStagingAdapter) Image(inputSource *string, assets []Asset, options *ImageOptions, outputTarget *string) (string, error) {
+	targetFile, err := createFullPathReference(*outputTarget, *a.StagingArea)
+	if err != nil {
+		return "", err
+	}
+	entries, err := commandEntries(*inputSource, assets, lambdaTaskRoot)
+	if err != nil {
+		return "", err
+	}
+	layerFile := targetFile + ".layer"
+	defer os.Remove(layerFile)
+	layer, diffId, err := writeImageLayer(entries, layerFile)
+	if err != nil {
+		return "", fmt.Errorf("could not create image layer: %w", err)
+	}
+
+	var base *imageLayout
+	manifest := &ociManifest{SchemaVersion: 2, Layers: make([]ociDescriptor, 0)}
+	imageConfig := map[string]interface{}{}
+	if options.BaseImage != "" {
+		base, err = readImageLayout(options.BaseImage)
+		if err != nil {
+			return "", fmt.Errorf("could not read base image %s: %w", options.BaseImage, err)
+		}
+		manifest, imageConfig, err = base.resolve(options.Architecture)
+		if err != nil {
+			return "", fmt.Errorf("base image %s: %w", options.BaseImage, err)
+		}
+	}
+	baseLayers := make(map[string]bool)
+	for _, baseLayer := range manifest.Layers {
+		baseLayers[baseLayer.Digest] = true
+	}
+	manifest.MediaType = ociManifestMediaType
+	manifest.Layers = append(manifest.Layers, layer.Descriptor)
+
+	configContent, err := json.Marshal(extendImageConfig(imageConfig, options.Architecture, diffId))
+	if err != nil {
+		return "", err
+	}
+	config := newImageBlob(ociConfigMediaType, configContent)
+	manifest.Config = config.Descriptor
+	manifestContent, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	manifestBlob := newImageBlob(ociManifestMediaType, manifestContent)
+	manifestBlob.Descriptor.Annotations = map[string]string{ociRefNameAnnotation: options.Tag}
+	index, err := json.Marshal(&ociIndex{
+		SchemaVersion: 2,
+		MediaType:     ociIndexMediaType,
+		Manifests:     []ociDescriptor{manifestBlob.Descriptor},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = writeImageLayoutFile(targetFile, index, base, baseLayers, []imageBlob{*layer, *config, *manifestBlob})
+	if err != nil {
+		return "", err
+	}
+	return manifestBlob.Descriptor.Digest, nil
+}
+
+func newImageBlob(mediaType string, content []byte) *imageBlob {
+	return &imageBlob{
+		Descriptor: ociDescriptor{
+			MediaType: mediaType,
+			Digest:    digestOf(content),
+			Size:      int64(len(content)),
+		},
+		Content: content,
+	}
+}
+
+func digestOf(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// writeImageLayer creates a deterministic gzipped tar layer in layerFile and returns it together with the digest of the
+// uncompressed tar.
+func writeImageLayer(entries []archiveEntry, layerFile string) (*imageBlob, string, error) {
+	sorted := make([]archiveEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	dirs := make(map[string]bool)
+	for _, entry := range sorted {
+		for dir := path.Dir(entry.Name); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	sortedDirs := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sortedDirs = append(sortedDirs, dir)
+	}
+	sort.Strings(sortedDirs)
+
+	file, err := os.Create(layerFile)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+	// The layer is streamed, the binary of a large function is never held in memory
+	layerHash := sha256.New()
+	gzipWriter, err := gzip.NewWriterLevel(io.MultiWriter(file, layerHash), gzip.BestCompression)
+	if err != nil {
+		return nil, "", err
+	}
+	diffIdHash := sha256.New()
+	tarWriter := tar.NewWriter(io.MultiWriter(gzipWriter, diffIdHash))
+	for _, dir := range sortedDirs {
+		err := tarWriter.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     dir + "/",
+			Mode:     0755,
+			ModTime:  archiveModified,
+			Format:   tar.FormatPAX,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	for _, entry := range sorted {
+		if err := addImageLayerEntry(tarWriter, entry); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return nil, "", err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, "", err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := file.Close(); err != nil {
+		return nil, "", err
+	}
+	return &imageBlob{
+		Descriptor: ociDescriptor{
+			MediaType: ociLayerMediaType,
+			Digest:    "sha256:" + hex.EncodeToString(layerHash.Sum(nil)),
+			Size:      info.Size(),
+		},
+		File: layerFile,
+	}, "sha256:" + hex.EncodeToString(diffIdHash.Sum(nil)), nil
+}
+
+func addImageLayerEntry(tarWriter *tar.Writer, entry archiveEntry) error {
+	source, err := os.Open(entry.Source)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+	err = tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     entry.Name,
+		Mode:     int64(entry.Mode),
+		Size:     info.Size(),
+		ModTime:  archiveModified,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tarWriter, source)
+	return err
+}
+
+// extendImageConfig keeps the settings of the base image, the command binary always becomes the entrypoint.
+func extendImageConfig(imageConfig map[string]interface{}, architecture string, diffId string) map[string]interface{} {
+	imageConfig["architecture"] = architecture
+	imageConfig["os"] = "linux"
+	imageConfig["created"] = archiveModified.Format("2006-01-02T15:04:05Z")
+
+	runConfig, ok := imageConfig["config"].(map[string]interface{})
+	if !ok {
+		runConfig = map[string]interface{}{
+			"Env": []interface{}{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"},
+		}
+	}
+	runConfig["Entrypoint"] = []interface{}{"/" + path.Join(lambdaTaskRoot, lambdaBootstrapName)}
+	runConfig["WorkingDir"] = "/" + lambdaTaskRoot
+	delete(runConfig, "Cmd")
+	imageConfig["config"] = runConfig
+
+	rootfs, ok := imageConfig["rootfs"].(map[string]interface{})
+	if !ok {
+		rootfs = map[string]interface{}{"type": "layers"}
+	}
+	diffIds, _ := rootfs["diff_ids"].([]interface{})
+	rootfs["diff_ids"] = append(diffIds, diffId)
+	imageConfig["rootfs"] = rootfs
+
+	// History has to account for every layer, a base image without history does not get one
+	history, hasHistory := imageConfig["history"].([]interface{})
+	if hasHistory || diffIds == nil {
+		imageConfig["history"] = append(history, map[string]interface{}{
+			"created":    imageConfig["created"],
+			"created_by": "gadget",
+		})
+	}
+	return imageConfig
+}
+
+// writeImageLayoutFile writes the layout with the index first, so it can be pushed in a single pass.
+func writeImageLayoutFile(targetFile string, index []byte, base *imageLayout, baseLayers map[string]bool, blobs []imageBlob) error {
+	file, err := os.Create(targetFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	tarWriter := tar.NewWriter(file)
+	if err := writeImageLayoutEntry(tarWriter, ociLayoutFile, []byte(ociLayoutContent)); err != nil {
+		return err
+	}
+	if err := writeImageLayoutEntry(tarWriter, ociIndexFile, index); err != nil {
+		return err
+	}
+	written := make(map[string]bool)
+	if base != nil {
+		if err := base.copyBlobs(tarWriter, baseLayers, written); err != nil {
+			return err
+		}
+	}
+	for _, blob := range blobs {
+		if written[blob.Descriptor.Digest] {
+			continue
+		}
+		written[blob.Descriptor.Digest] = true
+		if blob.File != "" {
+			if err := writeImageLayoutFileEntry(tarWriter, blobPath(blob.Descriptor.Digest), blob.File, blob.Descriptor.Size); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeImageLayoutEntry(tarWriter, blobPath(blob.Descriptor.Digest), blob.Content); err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func writeImageLayoutEntry(tarWriter *tar.Writer, name string, content []byte) error {
+	err := tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(assetMode),
+		Size:     int64(len(content)),
+		ModTime:  archiveModified,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tarWriter.Write(content)
+	return err
+}
+
+func writeImageLayoutFileEntry(tarWriter *tar.Writer, name string, sourceFile string, size int64) error {
+	source, err := os.Open(sourceFile)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	err = tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(assetMode),
+		Size:     size,
+		ModTime:  archiveModified,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tarWriter, source)
+	return err
+}
+
+func blobPath(digest string) string {
+	return path.Join("blobs", strings.Replace(digest, ":", "/", 1))
+}
+
+func digestOfBlobPath(name string) (string, bool) {
+	parts := strings.Split(path.Clean(name), "/")
+	if len(parts) != 3 || parts[0] != "blobs" {
+		return "", false
+	}
+	return parts[1] + ":" + parts[2], true
+}
+
+func readImageLayout(layoutFile string) (*imageLayout, error) {
+	layout := &imageLayout{
+		Path:  layoutFile,
+		Blobs: make(map[string][]byte),
+	}
+	err := walkImageLayout(layoutFile, func(header *tar.Header, content io.Reader) error {
+		if path.Clean(header.Name) == ociIndexFile {
+			layout.Index = &ociIndex{}
+			return json.NewDecoder(content).Decode(layout.Index)
+		}
+		digest, ok := digestOfBlobPath(header.Name)
+		if !ok || header.Size > maxBufferedImageBlobSize {
+			return nil
+		}
+		data, err := io.ReadAll(content)
+		if err != nil {
+			return err
+		}
+		layout.Blobs[digest] = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if layout.Index == nil {
+		return nil, fmt.Errorf("%s is not an OCI image layout, %s is missing", layoutFile, ociIndexFile)
+	}
+	return layout, nil
+}
+
+func walkImageLayout(layoutFile string, visit func(header *tar.Header, content io.Reader) error) error {
+	file, err := os.Open(layoutFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	tarReader := tar.NewReader(file)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := visit(header, tarReader); err != nil {
+			return err
+		}
+	}
+}
+
+// resolve selects the manifest for the architecture, descending into nested indexes of multi platform images.
+func (l *imageLayout) resolve(architecture string) (*ociManifest, map[string]interface{}, error) {
+	descriptor, err := l.selectManifest(l.Index, architecture)
+	if err != nil {
+		return nil, nil, err
+	}
+	var manifest ociManifest
+	if err := l.decodeBlob(descriptor.Digest, &manifest); err != nil {
+		return nil, nil, err
+	}
+	imageConfig := map[string]interface{}{}
+	if err := l.decodeBlob(manifest.Config.Digest, &imageConfig); err != nil {
+		return nil, nil, err
+	}
+	if declared, ok := imageConfig["architecture"].(string); ok && declared != architecture {
+		return nil, nil, fmt.Errorf("image is built for %s, the command for %s", declared, architecture)
+	}
+	return &manifest, imageConfig, nil
+}
+
+func (l *imageLayout) selectManifest(index *ociIndex, architecture string) (*ociDescriptor, error) {
+	for _, descriptor := range index.Manifests {
+		if descriptor.Platform != nil && (descriptor.Platform.OS != "linux" || descriptor.Platform.Architecture != architecture) {
+			continue
+		}
+		if descriptor.MediaType != ociIndexMediaType && descriptor.MediaType != dockerManifestListType {
+			return &descriptor, nil
+		}
+		var nested ociIndex
+		if err := l.decodeBlob(descriptor.Digest, &nested); err != nil {
+			return nil, err
+		}
+		return l.selectManifest(&nested, architecture)
+	}
+	return nil, fmt.Errorf("no manifest for linux/%s", architecture)
+}
+
+func (l *imageLayout) decodeBlob(digest string, target interface{}) error {
+	data, found := l.Blobs[digest]
+	if !found {
+		return fmt.Errorf("blob %s is missing", digest)
+	}
+	return json.Unmarshal(data, target)
+}
+
+// copyBlobs streams the blobs of the base image the new manifest refers to into the target layout.
+func (l *imageLayout) copyBlobs(tarWriter *tar.Writer, digests map[string]bool, written map[string]bool) error {
+	err := walkImageLayout(l.Path, func(header *tar.Header, content io.Reader) error {
+		digest, ok := digestOfBlobPath(header.Name)
+		if !ok || !digests[digest] || written[digest] {
+			return nil
+		}
+		written[digest] = true
+		err := tarWriter.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     blobPath(digest),
+			Mode:     int64(assetMode),
+			Size:     header.Size,
+			ModTime:  archiveModified,
+			Format:   tar.FormatPAX,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tarWriter, content)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for digest := range digests {
+		if !written[digest] {
+			return fmt.Errorf("base image %s is missing the layer %s", l.Path, digest)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package adapter
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+type StackPhase string
+
+const (
+	// StackPhaseNotFound means there is no stack yet, deploy creates it.
+	StackPhaseNotFound StackPhase = "NotFound"
+	// StackPhaseReview means the stack was created by a change set which was never executed, deploy creates it.
+	StackPhaseReview StackPhase = "Review"
+	// StackPhaseStable means the stack can be updated.
+	StackPhaseStable StackPhase = "Stable"
+	// StackPhaseInProgress means another operation is running, deploy waits for it.
+	StackPhaseInProgress StackPhase = "InProgress"
+	// StackPhaseRecreate means the initial creation failed, the stack has to be deleted before it can be created again.
+	StackPhaseRecreate StackPhase = "Recreate"
+	// StackPhaseUpdateRollbackFailed means the stack is stuck until the update rollback is continued.
+	StackPhaseUpdateRollbackFailed StackPhase = "UpdateRollbackFailed"
+	// StackPhaseBroken means the stack needs manual intervention.
+	StackPhaseBroken StackPhase = "Broken"
+)
+
+type stackStatusModel struct {
+	Phase      StackPhase
+	Successful bool
+}
+
+var stackStatusModels = map[types.StackStatus]stackStatusModel{
+	types.StackStatusCreateInProgress:                        {StackPhaseInProgress, false},
+	types.StackStatusCreateFailed:                            {StackPhaseRecreate, false},
+	types.StackStatusCreateComplete:                          {StackPhaseStable, true},
+	types.StackStatusRollbackInProgress:                      {StackPhaseInProgress, false},
+	types.StackStatusRollbackFailed:                          {StackPhaseRecreate, false},
+	types.StackStatusRollbackComplete:                        {StackPhaseRecreate, false},
+	types.StackStatusDeleteInProgress:                        {StackPhaseInProgress, false},
+	types.StackStatusDeleteFailed:                            {StackPhaseBroken, false},
+	types.StackStatusDeleteComplete:                          {StackPhaseNotFound, false},
+	types.StackStatusUpdateInProgress:                        {StackPhaseInProgress, false},
+	types.StackStatusUpdateCompleteCleanupInProgress:         {StackPhaseInProgress, true},
+	types.StackStatusUpdateComplete:                          {StackPhaseStable, true},
+	types.StackStatusUpdateFailed:                            {StackPhaseStable, false},
+	types.StackStatusUpdateRollbackInProgress:                {StackPhaseInProgress, false},
+	types.StackStatusUpdateRollbackFailed:                    {StackPhaseUpdateRollbackFailed, false},
+	types.StackStatusUpdateRollbackCompleteCleanupInProgress: {StackPhaseInProgress, false},
+	types.StackStatusUpdateRollbackComplete:                  {StackPhaseStable, false},
+	types.StackStatusReviewInProgress:                        {StackPhaseReview, false},
+	types.StackStatusImportInProgress:                        {StackPhaseInProgress, false},
+	types.StackStatusImportComplete:                          {StackPhaseStable, true},
+	types.StackStatusImportRollbackInProgress:                {StackPhaseInProgress, false},
+	types.StackStatusImportRollbackFailed:                    {StackPhaseBroken, false},
+	types.StackStatusImportRollbackComplete:                  {StackPhaseStable, false},
+}
+
+func modelStackStatus(status types.StackStatus) stackStatusModel {
+	if model, found := stackStatusModels[status]; found {
+		return model
+	}
+	return stackStatusModel{StackPhaseBroken, false}
+}
+
+// RequiresCreate reports whether the next deployment has to use a change set of type CREATE.
+func (s *DeploymentStatus) RequiresCreate() bool {
+	return s.Phase == StackPhaseNotFound || s.Phase == StackPhaseReview
+}
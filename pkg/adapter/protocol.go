@@ -0,0 +1,146 @@
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The generator protocol is the contract between gadget and the host compiled binary of a command.
+//
+// gadget first runs `<command> deployment describe`, which has to print a JSON document to stdout:
+//
+//	{"protocolVersion": 1, "flags": ["template", "s3bucket", "s3key", "handler", "application", "command", "architecture", "imageuri"]}
+//
+// It then runs `<command> deployment generate` with every flag listed in the description for which it has a value.
+// The generator writes the CloudFormation template of the command as YAML to the file passed with --template.
+// Generators which reject describe as an unknown command are treated as speaking the legacy protocol 0 and are
+// called with the fixed flag set gadget used before the handshake existed. Any other failure to describe is an error.
+const (
+	GeneratorProtocolLegacy  = 0
+	GeneratorProtocolVersion = 1
+)
+
+const (
+	GeneratorFlagTemplate     = "template"
+	GeneratorFlagS3Bucket     = "s3bucket"
+	GeneratorFlagS3Key        = "s3key"
+	GeneratorFlagHandler      = "handler"
+	GeneratorFlagApplication  = "application"
+	GeneratorFlagCommand      = "command"
+	GeneratorFlagArchitecture = "architecture"
+	GeneratorFlagImageUri     = "imageuri"
+)
+
+type (
+	GeneratorDescription struct {
+		ProtocolVersion int      `json:"protocolVersion"`
+		Flags           []string `json:"flags"`
+	}
+
+	generatorFlag struct {
+		Name     string
+		Value    *string
+		Required bool
+	}
+)
+
+// legacyGenerator describes generators predating the handshake, architecture was only passed when configured.
+var legacyGenerator = GeneratorDescription{
+	ProtocolVersion: GeneratorProtocolLegacy,
+	Flags: []string{
+		GeneratorFlagTemplate,
+		GeneratorFlagS3Bucket,
+		GeneratorFlagS3Key,
+		GeneratorFlagHandler,
+		GeneratorFlagApplication,
+		GeneratorFlagCommand,
+		GeneratorFlagArchitecture,
+	},
+}
+
+// unknownCommandOutputs are what generators predating the handshake print when asked to describe themselves:
+// urfave/cli, cobra and the flag package on an unknown command, cobra listing the subcommands of deployment, or
+// aws-lambda-go when the binary falls through to lambda.Start outside of Lambda.
+var unknownCommandOutputs = []string{
+	"no help topic for",
+	"unknown command",
+	"available commands:",
+	"flag provided but not defined",
+	"expected aws lambda environment variables",
+}
+
+func (d *GeneratorDescription) Supports(flag string) bool {
+	for _, supported := range d.Flags {
+		if supported == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *DefaultStagingAdapter) DescribeGenerator(ctx context.Context, commandName *string, inputSource *string) (*GeneratorDescription, error) {
+	sandbox, err := a.newGeneratorSandbox(*commandName)
+	if err != nil {
+		return nil, err
+	}
+	output, err := sandbox.run(ctx, *inputSource, "deployment", "describe")
+	if err != nil {
+		var failure *generatorFailure
+		if !errors.As(err, &failure) || !rejectsCommand(failure.Output) {
+			return nil, fmt.Errorf("could not describe generator %s: %w", *inputSource, err)
+		}
+		a.Logger.Debug("Generator does not know the describe command, assuming legacy protocol", "generator", *inputSource, "err", err)
+		description := legacyGenerator
+		return &description, nil
+	}
+	var description GeneratorDescription
+	if err := json.Unmarshal(output, &description); err != nil {
+		// cobra prints the help of deployment and exits successfully on an unknown subcommand
+		if rejectsCommand(output) {
+			a.Logger.Debug("Generator does not know the describe command, assuming legacy protocol", "generator", *inputSource)
+			description = legacyGenerator
+			return &description, nil
+		}
+		return nil, fmt.Errorf("generator %s did not print its description as JSON: %w", *inputSource, err)
+	}
+	if description.ProtocolVersion < GeneratorProtocolLegacy || description.ProtocolVersion > GeneratorProtocolVersion {
+		return nil, fmt.Errorf("generator %s speaks protocol version %d, this gadget supports versions %d to %d, align the gadget versions of the command and the CLI",
+			*inputSource, description.ProtocolVersion, GeneratorProtocolLegacy, GeneratorProtocolVersion)
+	}
+	return &description, nil
+}
+
+// rejectsCommand reports whether the output of a generator says that it does not know the command it was run with.
+func rejectsCommand(output []byte) bool {
+	text := strings.ToLower(string(output))
+	for _, unknown := range unknownCommandOutputs {
+		if strings.Contains(text, unknown) {
+			return true
+		}
+	}
+	return false
+}
+
+// generatorArguments passes every flag the generator supports, a required flag it does not know is a protocol violation.
+func (a *DefaultStagingAdapter) generatorArguments(description *GeneratorDescription, flags []generatorFlag) ([]string, error) {
+	args := []string{"deployment", "generate"}
+	for _, flag := range flags {
+		if !description.Supports(flag.Name) {
+			if flag.Required {
+				return nil, fmt.Errorf("generator speaking protocol version %d does not support the required flag --%s", description.ProtocolVersion, flag.Name)
+			}
+			if flag.Value != nil {
+				a.Logger.Debug("Generator does not support flag, skipping", "flag", flag.Name)
+			}
+			continue
+		}
+		if flag.Value == nil {
+			continue
+		}
+		args = append(args, "--"+flag.Name, *flag.Value)
+	}
+	return args, nil
+}
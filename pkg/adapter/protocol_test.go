@@ -0,0 +1,65 @@
+package adapter
+
+import "testing"
+
+func TestRejectsCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   bool
+	}{
+		{
+			name:   "urfave/cli unknown subcommand",
+			output: "No help topic for 'describe'\n",
+			want:   true,
+		},
+		{
+			name:   "urfave/cli unknown command",
+			output: "No help topic for 'deployment'\n",
+			want:   true,
+		},
+		{
+			name:   "cobra unknown command",
+			output: "Error: unknown command \"describe\" for \"hello\"\nRun 'hello --help' for usage.\n",
+			want:   true,
+		},
+		{
+			name: "cobra help of deployment",
+			output: "Usage:\n  hello deployment [command]\n\nAvailable Commands:\n  generate    \n\nFlags:\n" +
+				"  -h, --help   help for deployment\n\nUse \"hello deployment [command] --help\" for more information about a command.\n",
+			want: true,
+		},
+		{
+			name:   "flag package",
+			output: "flag provided but not defined: -describe\nUsage of hello:\n  -template string\n    \tfile to write the template to\n",
+			want:   true,
+		},
+		{
+			name:   "lambda.Start outside of Lambda",
+			output: "2024/01/02 15:04:05 expected AWS Lambda environment variables [_LAMBDA_SERVER_PORT AWS_LAMBDA_RUNTIME_API] are not defined\n",
+			want:   true,
+		},
+		{
+			name:   "failing describe printing its usage",
+			output: "Error: could not read gadget.yaml: open gadget.yaml: no such file or directory\nUsage:\n  hello deployment describe [flags]\n\nFlags:\n  -h, --help   help for describe\n",
+			want:   false,
+		},
+		{
+			name:   "flag package usage",
+			output: "Usage of hello:\n  -template string\n    \tfile to write the template to\n",
+			want:   false,
+		},
+		{
+			name:   "panic",
+			output: "panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\t/src/cmd/hello/main.go:12 +0x4dc\nexit status 2\n",
+			want:   false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := rejectsCommand([]byte(test.output)); got != test.want {
+				t.Errorf("rejectsCommand() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
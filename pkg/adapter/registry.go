@@ -0,0 +1,333 @@
+package adapter
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+type (
+	// RegistryAdapter pushes images to any registry implementing the OCI distribution API.
+	RegistryAdapter interface {
+		// PushImage uploads an OCI image layout tarball created by gadget, it reports false if the registry already has the image.
+		PushImage(ctx context.Context, layoutFile string, repository string, tag string) (bool, error)
+		// HasImage reports whether the registry holds the manifest of an image referenced by repository@digest.
+		HasImage(ctx context.Context, imageUri string) (bool, error)
+	}
+
+	DefaultRegistryAdapter struct {
+		Client   *http.Client
+		Username *string
+		Password *string
+		Insecure *bool
+		Logger   *log.Logger
+		// authorizations caches the Authorization header per registry and repository
+		authorizations map[string]string
+		mutex          sync.Mutex
+	}
+
+	imageRepository struct {
+		Registry string
+		Name     string
+	}
+)
+
+const (
+	// registryRequestTimeout bounds a single request, it leaves room to upload the layer of a large function
+	registryRequestTimeout = 15 * time.Minute
+	// registryResponseTimeout detects a registry which stalls after it received a request
+	registryResponseTimeout = 2 * time.Minute
+)
+
+func NewRegistryAdapter(username *string, password *string, insecure *bool, logger *log.Logger) (RegistryAdapter, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = registryResponseTimeout
+	return &DefaultRegistryAdapter{
+		Client: &http.Client{
+			Transport: transport,
+			Timeout:   registryRequestTimeout,
+		},
+		Username:       username,
+		Password:       password,
+		Insecure:       insecure,
+		Logger:         logger,
+		authorizations: make(map[string]string),
+	}, nil
+}
+
+// ImageUri references an image by digest, so the function always runs exactly the image built by gadget.
+func ImageUri(repository string, digest string) string {
+	return repository + "@" + digest
+}
+
+func parseImageRepository(repository string) (*imageRepository, error) {
+	registry, name, found := strings.Cut(repository, "/")
+	if !found || name == "" || !(strings.ContainsAny(registry, ".:") || registry == "localhost") {
+		return nil, fmt.Errorf("image repository %s has to start with the registry host, e.g. 123456789012.dkr.ecr.eu-central-1.amazonaws.com/%s", repository, repository)
+	}
+	return &imageRepository{Registry: registry, Name: name}, nil
+}
+
+func (a *DefaultRegistryAdapter) PushImage(ctx context.Context, layoutFile string, repository string, tag string) (bool, error) {
+	target, err := parseImageRepository(repository)
+	if err != nil {
+		return false, err
+	}
+	layout, err := readImageLayout(layoutFile)
+	if err != nil {
+		return false, err
+	}
+	if len(layout.Index.Manifests) != 1 {
+		return false, fmt.Errorf("image layout %s has to contain exactly one manifest", layoutFile)
+	}
+	manifest := layout.Index.Manifests[0]
+	manifestContent, found := layout.Blobs[manifest.Digest]
+	if !found {
+		return false, fmt.Errorf("image layout %s is missing its manifest %s", layoutFile, manifest.Digest)
+	}
+	exists, err := a.exists(ctx, target, "manifests", manifest.Digest)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		a.Logger.Debug("Image already present, skipping push", "repository", repository, "digest", manifest.Digest)
+		return false, nil
+	}
+
+	err = walkImageLayout(layoutFile, func(header *tar.Header, content io.Reader) error {
+		digest, ok := digestOfBlobPath(header.Name)
+		if !ok || digest == manifest.Digest {
+			return nil
+		}
+		exists, err := a.exists(ctx, target, "blobs", digest)
+		if err != nil || exists {
+			return err
+		}
+		a.Logger.Debug("Uploading image blob", "repository", repository, "digest", digest, "size", header.Size)
+		return a.uploadBlob(ctx, target, digest, header.Size, content)
+	})
+	if err != nil {
+		return false, fmt.Errorf("error pushing image %s: %w", repository, err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, a.endpoint(target, "manifests", tag), bytes.NewReader(manifestContent))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", manifest.MediaType)
+	response, err := a.do(target, request)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		return false, registryError(request, response)
+	}
+	a.Logger.Debug("Pushed image", "repository", repository, "tag", tag, "digest", manifest.Digest)
+	return true, nil
+}
+
+func (a *DefaultRegistryAdapter) HasImage(ctx context.Context, imageUri string) (bool, error) {
+	repository, digest, found := strings.Cut(imageUri, "@")
+	if !found {
+		return false, fmt.Errorf("image %s is not referenced by digest", imageUri)
+	}
+	target, err := parseImageRepository(repository)
+	if err != nil {
+		return false, err
+	}
+	return a.exists(ctx, target, "manifests", digest)
+}
+
+func (a *DefaultRegistryAdapter) endpoint(target *imageRepository, kind string, reference string) string {
+	scheme := "https"
+	if *a.Insecure {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", scheme, target.Registry, target.Name, kind, reference)
+}
+
+func (a *DefaultRegistryAdapter) exists(ctx context.Context, target *imageRepository, kind string, digest string) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, a.endpoint(target, kind, digest), nil)
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Accept", ociManifestMediaType)
+	response, err := a.do(target, request)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, registryError(request, response)
+}
+
+// uploadBlob uses a monolithic upload, the content is streamed from the layout without buffering.
+func (a *DefaultRegistryAdapter) uploadBlob(ctx context.Context, target *imageRepository, digest string, size int64, content io.Reader) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint(target, "blobs", "uploads/"), nil)
+	if err != nil {
+		return err
+	}
+	response, err := a.do(target, request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusAccepted {
+		return registryError(request, response)
+	}
+	location, err := request.URL.Parse(response.Header.Get("Location"))
+	if err != nil {
+		return fmt.Errorf("registry returned an invalid upload location: %w", err)
+	}
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+
+	request, err = http.NewRequestWithContext(ctx, http.MethodPut, location.String(), io.NopCloser(content))
+	if err != nil {
+		return err
+	}
+	request.ContentLength = size
+	request.Header.Set("Content-Type", "application/octet-stream")
+	response, err = a.do(target, request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		return registryError(request, response)
+	}
+	return nil
+}
+
+// do sends the request with the cached authorization and authenticates once if the registry asks for it.
+// Requests with a streamed body cannot be repeated, the preceding requests have already authenticated.
+func (a *DefaultRegistryAdapter) do(target *imageRepository, request *http.Request) (*http.Response, error) {
+	key := target.Registry + "/" + target.Name
+	a.mutex.Lock()
+	authorization := a.authorizations[key]
+	a.mutex.Unlock()
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	response, err := a.Client.Do(request)
+	if err != nil || response.StatusCode != http.StatusUnauthorized {
+		return response, err
+	}
+	if request.Body != nil && request.GetBody == nil {
+		return response, nil
+	}
+	challenge := response.Header.Get("WWW-Authenticate")
+	response.Body.Close()
+	authorization, err = a.authenticate(request.Context(), target, challenge)
+	if err != nil {
+		return nil, err
+	}
+	a.mutex.Lock()
+	a.authorizations[key] = authorization
+	a.mutex.Unlock()
+
+	retry := request.Clone(request.Context())
+	if request.GetBody != nil {
+		retry.Body, err = request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", authorization)
+	return a.Client.Do(retry)
+}
+
+// authenticate answers a basic or bearer token challenge with the configured credentials.
+func (a *DefaultRegistryAdapter) authenticate(ctx context.Context, target *imageRepository, challenge string) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if a.Username == nil || *a.Username == "" {
+			return "", fmt.Errorf("registry %s requires credentials, pass --registry-username and --registry-password", target.Registry)
+		}
+		request, _ := http.NewRequest(http.MethodGet, "/", nil)
+		request.SetBasicAuth(*a.Username, *a.Password)
+		return request.Header.Get("Authorization"), nil
+	case "bearer":
+		tokenUrl, err := url.Parse(params["realm"])
+		if err != nil || params["realm"] == "" {
+			return "", fmt.Errorf("registry %s sent an invalid token realm %q", target.Registry, params["realm"])
+		}
+		scope := params["scope"]
+		if scope == "" {
+			scope = fmt.Sprintf("repository:%s:pull,push", target.Name)
+		}
+		query := tokenUrl.Query()
+		query.Set("scope", scope)
+		if params["service"] != "" {
+			query.Set("service", params["service"])
+		}
+		tokenUrl.RawQuery = query.Encode()
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenUrl.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		if a.Username != nil && *a.Username != "" {
+			request.SetBasicAuth(*a.Username, *a.Password)
+		}
+		response, err := a.Client.Do(request)
+		if err != nil {
+			return "", err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return "", registryError(request, response)
+		}
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+			return "", fmt.Errorf("could not read registry token: %w", err)
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		return "Bearer " + token.Token, nil
+	}
+	return "", fmt.Errorf("registry %s requested unsupported authentication %q", target.Registry, challenge)
+}
+
+// parseChallenge splits a WWW-Authenticate header into its scheme and parameters, quoted values may contain commas.
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, ", "), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return scheme, params
+}
+
+func registryError(request *http.Request, response *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+	return fmt.Errorf("registry request %s %s failed with %s: %s", request.Method, request.URL.Redacted(), response.Status, strings.TrimSpace(string(body)))
+}
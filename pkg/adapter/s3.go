@@ -3,10 +3,13 @@ package adapter
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"os"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 type (
@@ -14,19 +17,84 @@ type (
 		Client *s3.Client
 	}
 
+	S3FileInfo struct {
+		Size int64
+	}
+
 	S3Adapter interface {
 		UploadFile(ctx context.Context, localfileName string, bucketName string, bucketKey string) error
 		CreateFile(ctx context.Context, contents string, bucketName string, bucketKey string) error
+		HeadFile(ctx context.Context, bucketName string, bucketKey string) (*S3FileInfo, error)
+		DeleteFiles(ctx context.Context, bucketName string, prefix string) (int, error)
 	}
 )
 
+// DeleteFiles implements S3Adapter. It removes every file below the prefix and returns how many were deleted.
+func (s *S3SDK) DeleteFiles(ctx context.Context, bucketName string, prefix string) (int, error) {
+	deleted := 0
+	paginator := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket: &bucketName,
+		Prefix: &prefix,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return deleted, err
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+		objects := make([]types.ObjectIdentifier, 0, len(page.Contents))
+		for _, object := range page.Contents {
+			objects = append(objects, types.ObjectIdentifier{Key: object.Key})
+		}
+		quiet := true
+		resp, err := s.Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &bucketName,
+			Delete: &types.Delete{
+				Objects: objects,
+				Quiet:   &quiet,
+			},
+		})
+		if err != nil {
+			return deleted, err
+		}
+		if len(resp.Errors) > 0 {
+			failed := resp.Errors[0]
+			return deleted, fmt.Errorf("could not delete %s from bucket %s: %s", *failed.Key, bucketName, *failed.Message)
+		}
+		deleted += len(objects)
+	}
+	return deleted, nil
+}
+
+// HeadFile implements S3Adapter. It returns nil if the file does not exist.
+func (s *S3SDK) HeadFile(ctx context.Context, bucketName string, bucketKey string) (*S3FileInfo, error) {
+	resp, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &bucketName,
+		Key:    &bucketKey,
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	info := &S3FileInfo{}
+	if resp.ContentLength != nil {
+		info.Size = *resp.ContentLength
+	}
+	return info, nil
+}
+
 // UploadFile implements S3Adapter.
 func (s *S3SDK) UploadFile(ctx context.Context, localfileName string, bucketName string, bucketKey string) error {
 	data, err := os.ReadFile(localfileName)
 	if err != nil {
 		return err
 	}
-	_, err = s.Client.PutObject(context.TODO(), &s3.PutObjectInput{
+	_, err = s.Client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket: &bucketName,
 		Key:    &bucketKey,
 		Body:   bytes.NewReader(data),
@@ -39,7 +107,7 @@ func (s *S3SDK) UploadFile(ctx context.Context, localfileName string, bucketName
 
 // CreateFile implements S3Adapter.
 func (s *S3SDK) CreateFile(ctx context.Context, contents string, bucketName string, bucketKey string) error {
-	_, err := s.Client.PutObject(context.TODO(), &s3.PutObjectInput{
+	_, err := s.Client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket: &bucketName,
 		Key:    &bucketKey,
 		Body:   bytes.NewReader([]byte(contents)),
@@ -50,8 +118,8 @@ func (s *S3SDK) CreateFile(ctx context.Context, contents string, bucketName stri
 	return nil
 }
 
-func NewS3Adapter() (S3Adapter, error) {
-	client, err := createS3Client(context.Background())
+func NewS3Adapter(ctx context.Context) (S3Adapter, error) {
+	client, err := createS3Client(ctx)
 	if err != nil {
 		return nil, err
 	}
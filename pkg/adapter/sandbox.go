@@ -0,0 +1,143 @@
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+type (
+	// generatorSandbox runs the host compiled binary of a command with a minimal environment,
+	// inside its own directory of the staging area and for a limited time.
+	generatorSandbox struct {
+		Command string
+		WorkDir string
+		Timeout time.Duration
+		Logger  *log.Logger
+	}
+
+	// generatorFailure is returned when a generator exits unsuccessfully, its output tells why.
+	generatorFailure struct {
+		Command string
+		Output  []byte
+		Err     error
+	}
+
+	// lineLogger forwards everything written to it line by line to a logger.
+	lineLogger struct {
+		Logger  *log.Logger
+		pending []byte
+		mutex   sync.Mutex
+	}
+)
+
+// generatorEnvironment lists the variables passed through to generators, anything else including
+// cloud credentials stays with gadget.
+var generatorEnvironment = []string{"PATH", "LANG", "LC_ALL", "TZ"}
+
+func (a *DefaultStagingAdapter) newGeneratorSandbox(command string) (*generatorSandbox, error) {
+	workDir, err := filepath.Abs(filepath.Join(*a.StagingArea, "generators", command))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(workDir, "tmp"), 0755); err != nil {
+		return nil, err
+	}
+	return &generatorSandbox{
+		Command: command,
+		WorkDir: workDir,
+		Timeout: *a.GeneratorTimeout,
+		Logger:  a.Logger.WithPrefix(command),
+	}, nil
+}
+
+func (s *generatorSandbox) environment() []string {
+	env := make([]string, 0, len(generatorEnvironment)+2)
+	for _, name := range generatorEnvironment {
+		if value, found := os.LookupEnv(name); found {
+			env = append(env, fmt.Sprintf("%s=%s", name, value))
+		}
+	}
+	return append(env,
+		fmt.Sprintf("HOME=%s", s.WorkDir),
+		fmt.Sprintf("TMPDIR=%s", filepath.Join(s.WorkDir, "tmp")),
+	)
+}
+
+// run executes the generator and returns its stdout, stderr is streamed to the logger while it runs.
+func (s *generatorSandbox) run(ctx context.Context, generator string, args ...string) ([]byte, error) {
+	// The working directory changes, relative references would no longer resolve
+	generator, err := filepath.Abs(generator)
+	if err != nil {
+		return nil, err
+	}
+	runCtx, cancel := ctx, context.CancelFunc(func() {})
+	if s.Timeout > 0 {
+		runCtx, cancel = context.WithTimeout(ctx, s.Timeout)
+	}
+	defer cancel()
+
+	command := exec.CommandContext(runCtx, generator, args...)
+	command.Dir = s.WorkDir
+	command.Env = s.environment()
+	var stdout, output bytes.Buffer
+	command.Stdout = io.MultiWriter(&stdout, &output)
+	stderr := &lineLogger{Logger: s.Logger}
+	command.Stderr = io.MultiWriter(stderr, &output)
+	s.Logger.Debug("Running generator", "args", strings.Join(args, " "))
+
+	err = command.Run()
+	stderr.Flush()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("generator %s was aborted: %w", s.Command, ctx.Err())
+		}
+		if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("generator %s timed out after %s", s.Command, s.Timeout)
+		}
+		return nil, &generatorFailure{Command: s.Command, Output: output.Bytes(), Err: err}
+	}
+	return stdout.Bytes(), nil
+}
+
+func (f *generatorFailure) Error() string {
+	return fmt.Sprintf("generator %s failed: %s", f.Command, f.Err)
+}
+
+func (f *generatorFailure) Unwrap() error {
+	return f.Err
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.pending = append(l.pending, p...)
+	for {
+		index := bytes.IndexByte(l.pending, '\n')
+		if index < 0 {
+			break
+		}
+		l.Logger.Info(strings.TrimRight(string(l.pending[:index]), "\r"))
+		l.pending = l.pending[index+1:]
+	}
+	return len(p), nil
+}
+
+func (l *lineLogger) Flush() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.pending) > 0 {
+		l.Logger.Info(string(l.pending))
+		l.pending = nil
+	}
+}
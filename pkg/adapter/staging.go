@@ -1,37 +1,118 @@
 package adapter
 
 import (
-	"archive/zip"
 	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
 	"errors"
 	"fmt"
-	"io"
+	"io/fs"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/stefan79/gadget-cli/pkg/adapter/util"
+	"gopkg.in/yaml.v2"
 )
 
 type (
 	DefaultStagingAdapter struct {
 		ApplicationName *string
 		StagingArea     *string
+		BuildCache      *BuildCache
+		NoCache         *bool
+		// GeneratorTimeout limits every run of a command template generator, 0 disables the limit
+		GeneratorTimeout *time.Duration
+		Logger           *log.Logger
+		version          string
+		versionOnce      sync.Once
+	}
+
+	// BuildSettings are applied to the local as well as to the cross compiled build of a command.
+	BuildSettings struct {
+		Tags []string
+		// Ldflags and Gcflags hold the arguments of the flags, elements starting with a dash are split at whitespace,
+		// e.g. [-X main.version=1] or [-X, main.greeting=hello world]
+		Ldflags []string
+		Gcflags []string
+		Cgo     *bool
+		Env     map[string]string
+		// VersionVariable is set to the version of the workspace through -ldflags -X
+		VersionVariable string
+		// Strip removes the symbol table and DWARF data through -ldflags -s -w
+		Strip bool
 	}
+
 	StagingAdapter interface {
 		CalculateCheckSum(inputSource *string) (string, error)
 		GetFileFromStaging(base *string) (*string, error)
-		Compile(inputSource *string, outputTarget *string) error
-		GenerateTemplate(inputSource *string, outputTarget *string, handlerName *string, s3Bucket *string, s3Key *string) error
-		CompileWithOptions(inputSource *string, outputTarget *string, options map[string]string) error
-		Zip(inputSource *string, outputTarget *string) error
+		Compile(ctx context.Context, inputSource *string, outputTarget *string, settings *BuildSettings) error
+		DescribeGenerator(ctx context.Context, commandName *string, inputSource *string) (*GeneratorDescription, error)
+		GenerateTemplate(ctx context.Context, inputSource *string, outputTarget *string, handlerName *string, s3Bucket *string, s3Key *string, architecture *string, imageUri *string) error
+		CompileWithOptions(ctx context.Context, inputSource *string, outputTarget *string, options map[string]string, settings *BuildSettings) error
+		ResolveAssets(baseDir string, patterns []string) ([]Asset, error)
+		Zip(inputSource *string, assets []Asset, outputTarget *string) error
+		ZipLayer(assets []Asset, outputTarget *string) error
+		Image(inputSource *string, assets []Asset, options *ImageOptions, outputTarget *string) (string, error)
+		SaveArtifactManifest(fileName *string, manifest *ArtifactManifest) error
+		LoadArtifactManifest(fileName *string) (*ArtifactManifest, error)
+	}
+
+	// Asset is an additional file packaged next to the bootstrap binary.
+	Asset struct {
+		Name   string
+		Source string
+	}
+
+	Artifact struct {
+		Command      string
+		Architecture string
+		PackageType  string
+		Archive      string
+		Checksum     string
+		Bucket       string
+		Key          string
+		Image        string
+		ImageUri     string
+		ImageTag     string
+		BinarySize   int64
+		ArchiveSize  int64
+		Uploaded     bool
+		Template     string
+	}
+
+	LayerArtifact struct {
+		Layer    string
+		Archive  string
+		Checksum string
+		Bucket   string
+		Key      string
+		Uploaded bool
+	}
+
+	ArtifactManifest struct {
+		Application string
+		Artifacts   []*Artifact
+		Layers      []*LayerArtifact `yaml:"layers,omitempty"`
 	}
 )
 
-func NewStagingAdapter(applicationName *string, stagingArea *string) (StagingAdapter, error) {
+func NewStagingAdapter(applicationName *string, stagingArea *string, workArea *string, noCache *bool, generatorTimeout *time.Duration, logger *log.Logger) (StagingAdapter, error) {
+	buildCache, err := LoadBuildCache(filepath.Join(*workArea, "build-cache.yaml"))
+	if err != nil {
+		return nil, err
+	}
 	return &DefaultStagingAdapter{
-		StagingArea:     stagingArea,
-		ApplicationName: applicationName,
+		StagingArea:      stagingArea,
+		ApplicationName:  applicationName,
+		BuildCache:       buildCache,
+		NoCache:          noCache,
+		GeneratorTimeout: generatorTimeout,
+		Logger:           logger,
 	}, nil
 }
 
@@ -51,95 +132,373 @@ func (a *DefaultStagingAdapter) CalculateCheckSum(inputSource *string) (string,
 	if err != nil {
 		return "", err
 	}
-	// Open file
-	file, err := os.Open(targetFile)
+	return checksumFile(targetFile)
+}
+
+func (a *DefaultStagingAdapter) Compile(ctx context.Context, inputSource *string, outputTarget *string, settings *BuildSettings) error {
+	targetFile, err := createFullPathReference(*outputTarget, *a.StagingArea)
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer file.Close()
+	options := make(map[string]string)
+	return a.build(ctx, *inputSource, targetFile, options, settings)
+}
 
-	// Create a new SHA256 hash
-	hash := sha256.New()
+func (a *DefaultStagingAdapter) CompileWithOptions(ctx context.Context, inputSource *string, outputTarget *string, options map[string]string, settings *BuildSettings) error {
+	targetFile, err := createFullPathReference(*outputTarget, *a.StagingArea)
+	if err != nil {
+		return err
+	}
+	return a.build(ctx, *inputSource, targetFile, options, settings)
+}
 
-	// Copy the file content to the hash
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", err
+// build runs go build unless the build cache already holds a binary built from identical inputs.
+func (a *DefaultStagingAdapter) build(ctx context.Context, inputSource string, targetFile string, options map[string]string, settings *BuildSettings) error {
+	// -trimpath and a disabled VCS stamp keep machine specific paths and checkout state out of the binary,
+	// identical sources then produce identical binaries on every machine.
+	args := []string{"build", "-trimpath", "-buildvcs=false"}
+	env := make(map[string]string)
+	if settings != nil {
+		flags, err := a.buildFlags(ctx, settings)
+		if err != nil {
+			return err
+		}
+		args = append(args, flags...)
+		for key, value := range settings.Env {
+			env[key] = value
+		}
+		if settings.Cgo != nil {
+			env["CGO_ENABLED"] = "0"
+			if *settings.Cgo {
+				env["CGO_ENABLED"] = "1"
+			}
+		}
+	}
+	// The target platform always wins over user supplied variables
+	for key, value := range options {
+		env[key] = value
+	}
+	args = append(args, "-o", targetFile, inputSource)
+	options = env
+	if *a.NoCache {
+		return runCommand(ctx, "go", options, args...)
 	}
+	key, err := computeBuildKey(ctx, inputSource, options, args)
+	if err != nil {
+		return fmt.Errorf("could not compute build cache key: %w", err)
+	}
+	if a.BuildCache.Lookup(targetFile, key) {
+		a.Logger.Debug("Build cache hit, skipping build", "target", filepath.Base(targetFile))
+		return nil
+	}
+	if err := runCommand(ctx, "go", options, args...); err != nil {
+		return err
+	}
+	return a.BuildCache.Store(targetFile, key)
+}
 
-	// Get the SHA256 checksum
-	checksum := hash.Sum(nil)
+func (a *DefaultStagingAdapter) buildFlags(ctx context.Context, settings *BuildSettings) ([]string, error) {
+	flags := make([]string, 0)
+	if len(settings.Tags) > 0 {
+		flags = append(flags, "-tags", strings.Join(settings.Tags, ","))
+	}
+	pattern, ldflags := splitFlags(settings.Ldflags)
+	if settings.Strip {
+		ldflags = stripFlags(ldflags)
+	}
+	if settings.VersionVariable != "" {
+		ldflags = append(ldflags, "-X", fmt.Sprintf("%s=%s", settings.VersionVariable, a.workspaceVersion(ctx)))
+	}
+	if pattern != "" || len(ldflags) > 0 {
+		joined, err := joinFlags(pattern, ldflags)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ldflags: %w", err)
+		}
+		flags = append(flags, "-ldflags", joined)
+	}
+	if len(settings.Gcflags) > 0 {
+		joined, err := joinFlags(splitFlags(settings.Gcflags))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gcflags: %w", err)
+		}
+		flags = append(flags, "-gcflags", joined)
+	}
+	return flags, nil
+}
 
-	// Convert the checksum to a hexadecimal string
-	checksumStr := hex.EncodeToString(checksum)
+// splitFlags turns the configured elements of -ldflags or -gcflags into single arguments. Elements starting with a
+// dash are split at whitespace, so the common -X main.version=1 keeps working, a leading package pattern like
+// all=-N -l is returned apart from the arguments.
+func splitFlags(elements []string) (string, []string) {
+	pattern := ""
+	arguments := make([]string, 0, len(elements))
+	for i, element := range elements {
+		if i == 0 && !strings.HasPrefix(element, "-") {
+			if prefix, rest, found := strings.Cut(element, "="); found && prefix != "" && !strings.ContainsAny(prefix, " \t\n\r'\"") &&
+				(rest == "" || strings.HasPrefix(rest, "-")) {
+				pattern, element = prefix, rest
+			}
+		}
+		if strings.HasPrefix(element, "-") {
+			arguments = append(arguments, strings.Fields(element)...)
+			continue
+		}
+		arguments = append(arguments, element)
+	}
+	return pattern, arguments
+}
 
-	return checksumStr, nil
+// joinFlags joins arguments the way go build splits them again, every argument stays a single argument.
+// Arguments containing whitespace are quoted, e.g. -X 'main.greeting=hello world'.
+func joinFlags(pattern string, arguments []string) (string, error) {
+	quoted := make([]string, 0, len(arguments))
+	for _, argument := range arguments {
+		switch {
+		case argument != "" && !strings.ContainsAny(argument, " \t\n\r") && argument[0] != '\'' && argument[0] != '"':
+			quoted = append(quoted, argument)
+		case !strings.Contains(argument, "'"):
+			quoted = append(quoted, "'"+argument+"'")
+		case !strings.Contains(argument, `"`):
+			quoted = append(quoted, `"`+argument+`"`)
+		default:
+			return "", fmt.Errorf("%q contains both ' and \", go build cannot pass it as one argument", argument)
+		}
+	}
+	joined := strings.Join(quoted, " ")
+	if pattern != "" {
+		return pattern + "=" + joined, nil
+	}
+	if !strings.HasPrefix(joined, "-") {
+		return "", fmt.Errorf("%q has to follow a flag, go build would take it for a package pattern, e.g. write [-X, main.version=1]", arguments[0])
+	}
+	return joined, nil
 }
 
-func (a *DefaultStagingAdapter) Compile(inputSource *string, outputTarget *string) error {
-	targetFile, err := createFullPathReference(*outputTarget, *a.StagingArea)
-	if err != nil {
-		return err
+// stripFlags adds -s and -w unless they were configured explicitly.
+func stripFlags(ldflags []string) []string {
+	stripped := make([]string, 0, len(ldflags)+2)
+	for _, flag := range []string{"-s", "-w"} {
+		found := false
+		for _, existing := range ldflags {
+			if existing == flag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			stripped = append(stripped, flag)
+		}
 	}
-	options := make(map[string]string)
-	err = runCommand("go", options, "build", "-o", targetFile, *inputSource)
-	return err
+	return append(stripped, ldflags...)
+}
+
+// workspaceVersion describes the checked out revision, it falls back to dev outside of a git repository.
+func (a *DefaultStagingAdapter) workspaceVersion(ctx context.Context) string {
+	a.versionOnce.Do(func() {
+		a.version = "dev"
+		output, err := runCommandWithOutput(ctx, "git", nil, "describe", "--tags", "--always", "--dirty")
+		if err != nil {
+			a.Logger.Debug("Could not describe workspace version", "err", err)
+			return
+		}
+		a.version = strings.TrimSpace(string(output))
+	})
+	return a.version
 }
 
-func (a *DefaultStagingAdapter) CompileWithOptions(inputSource *string, outputTarget *string, options map[string]string) error {
+func (a *DefaultStagingAdapter) Zip(inputSource *string, assets []Asset, outputTarget *string) error {
 	targetFile, err := createFullPathReference(*outputTarget, *a.StagingArea)
 	if err != nil {
 		return err
 	}
-	err = runCommand("go", options, "build", "-o", targetFile, *inputSource)
-	return err
+	entries, err := commandEntries(*inputSource, assets, "")
+	if err != nil {
+		return err
+	}
+	return a.writeCachedArchive(targetFile, entries, func() error {
+		return verifyLambdaArchive(targetFile, *inputSource)
+	})
+}
+
+// commandEntries places the binary as bootstrap next to the assets of a command in root. Only commands reserve the
+// name, a layer of a custom runtime ships its own bootstrap.
+func commandEntries(inputSource string, assets []Asset, root string) ([]archiveEntry, error) {
+	entries := []archiveEntry{
+		{
+			Name:   path.Join(root, lambdaBootstrapName),
+			Source: inputSource,
+			Mode:   lambdaBootstrapMode,
+		},
+	}
+	for _, asset := range assets {
+		if asset.Name == lambdaBootstrapName {
+			return nil, fmt.Errorf("asset %s would replace the %s binary", asset.Source, lambdaBootstrapName)
+		}
+		entries = append(entries, archiveEntry{
+			Name:   path.Join(root, asset.Name),
+			Source: asset.Source,
+			Mode:   assetMode,
+		})
+	}
+	return entries, nil
 }
 
-func (a *DefaultStagingAdapter) Zip(inputSource *string, outputTarget *string) error {
+// ZipLayer packages the files of a layer, executables like extensions keep their executable bit.
+func (a *DefaultStagingAdapter) ZipLayer(assets []Asset, outputTarget *string) error {
 	targetFile, err := createFullPathReference(*outputTarget, *a.StagingArea)
 	if err != nil {
 		return err
 	}
-	zipFile, err := os.Create(targetFile)
-	if err != nil {
+	entries := make([]archiveEntry, 0, len(assets))
+	for _, asset := range assets {
+		info, err := os.Stat(asset.Source)
+		if err != nil {
+			return err
+		}
+		mode := assetMode
+		if info.Mode().Perm()&0111 != 0 {
+			mode = lambdaBootstrapMode
+		}
+		entries = append(entries, archiveEntry{
+			Name:   asset.Name,
+			Source: asset.Source,
+			Mode:   mode,
+		})
+	}
+	return a.writeCachedArchive(targetFile, entries, nil)
+}
+
+// writeCachedArchive writes a zip unless the build cache holds one of identical entries, verify checks a fresh
+// archive before it is cached.
+func (a *DefaultStagingAdapter) writeCachedArchive(targetFile string, entries []archiveEntry, verify func() error) error {
+	var key string
+	if !*a.NoCache {
+		var err error
+		key, err = computeArchiveKey(entries)
+		if err != nil {
+			return fmt.Errorf("could not compute archive cache key: %w", err)
+		}
+		if a.BuildCache.Lookup(targetFile, key) {
+			a.Logger.Debug("Build cache hit, skipping archive", "target", filepath.Base(targetFile))
+			return nil
+		}
+	}
+	if err := writeArchive(targetFile, entries); err != nil {
 		return err
 	}
-	defer zipFile.Close()
+	if verify != nil {
+		if err := verify(); err != nil {
+			return err
+		}
+	}
+	if *a.NoCache {
+		return nil
+	}
+	return a.BuildCache.Store(targetFile, key)
+}
 
-	// Create a new zip archive
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
+// ResolveAssets expands the asset patterns of a command or layer, every match is named by its path relative to the base directory.
+func (a *DefaultStagingAdapter) ResolveAssets(baseDir string, patterns []string) ([]Asset, error) {
+	assets := make([]Asset, 0)
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(baseDir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid asset pattern %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("asset pattern %s does not match any file in %s", pattern, baseDir)
+		}
+		for _, match := range matches {
+			err = filepath.WalkDir(match, func(path string, entry fs.DirEntry, err error) error {
+				if err != nil || entry.IsDir() {
+					return err
+				}
+				if !entry.Type().IsRegular() {
+					return fmt.Errorf("asset %s is not a regular file", path)
+				}
+				name, err := filepath.Rel(baseDir, path)
+				if err != nil {
+					return err
+				}
+				name = filepath.ToSlash(name)
+				if strings.HasPrefix(name, "../") {
+					return fmt.Errorf("asset %s is outside of %s", path, baseDir)
+				}
+				if !seen[name] {
+					seen[name] = true
+					assets = append(assets, Asset{Name: name, Source: path})
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return assets, nil
+}
 
-	// Open source file
-	fileToZip, err := os.Open(*inputSource)
+func (a *DefaultStagingAdapter) SaveArtifactManifest(fileName *string, manifest *ArtifactManifest) error {
+	targetFile, err := createFullPathReference(*fileName, *a.StagingArea)
 	if err != nil {
 		return err
 	}
-	defer fileToZip.Close()
+	return util.SaveYAMLFile(targetFile, manifest)
+}
 
-	// Create a writer for the file
-	writer, err := zipWriter.Create(filepath.Base(*inputSource))
+func (a *DefaultStagingAdapter) LoadArtifactManifest(fileName *string) (*ArtifactManifest, error) {
+	targetFile, err := createFullPathReference(*fileName, *a.StagingArea)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	// Copy the file content to the zip archive
-	_, err = io.Copy(writer, fileToZip)
-	return err
+	data, err := os.ReadFile(targetFile)
+	if err != nil {
+		return nil, err
+	}
+	var manifest ArtifactManifest
+	err = yaml.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	return &manifest, nil
 }
 
-func (a *DefaultStagingAdapter) GenerateTemplate(inputSource *string, outputTarget *string, handlerName *string, s3bucket *string, s3key *string) error {
+func (a *DefaultStagingAdapter) GenerateTemplate(ctx context.Context, inputSource *string, outputTarget *string, handlerName *string, s3bucket *string, s3key *string, architecture *string, imageUri *string) error {
 	targetFile, err := createFullPathReference(*outputTarget, *a.StagingArea)
 	if err != nil {
 		return err
 	}
-	err = runCommand(*inputSource, nil, "deployment", "generate",
-		"--template", targetFile,
-		"--s3bucket", *s3bucket,
-		"--s3key", *s3key,
-		"--handler", *handlerName,
-		"--application", *a.ApplicationName,
-		"--command", *handlerName,
-	)
+	// The generator runs inside its own working directory
+	targetFile, err = filepath.Abs(targetFile)
+	if err != nil {
+		return err
+	}
+	description, err := a.DescribeGenerator(ctx, handlerName, inputSource)
+	if err != nil {
+		return err
+	}
+	a.Logger.Debug("Generator described", "generator", *inputSource, "protocol", description.ProtocolVersion)
+	args, err := a.generatorArguments(description, []generatorFlag{
+		{Name: GeneratorFlagTemplate, Value: &targetFile, Required: true},
+		// Images are referenced by their uri, gadget rewrites the code of the functions either way
+		{Name: GeneratorFlagS3Bucket, Value: s3bucket, Required: imageUri == nil},
+		{Name: GeneratorFlagS3Key, Value: s3key, Required: imageUri == nil},
+		{Name: GeneratorFlagHandler, Value: handlerName},
+		{Name: GeneratorFlagApplication, Value: a.ApplicationName},
+		{Name: GeneratorFlagCommand, Value: handlerName},
+		// Only passed when configured, legacy generators predating architectures do not know the flag
+		{Name: GeneratorFlagArchitecture, Value: architecture},
+		{Name: GeneratorFlagImageUri, Value: imageUri},
+	})
+	if err != nil {
+		return fmt.Errorf("generator %s: %w", *inputSource, err)
+	}
+	sandbox, err := a.newGeneratorSandbox(*handlerName)
+	if err != nil {
+		return err
+	}
+	_, err = sandbox.run(ctx, *inputSource, args...)
 	return err
 }
 
@@ -151,29 +510,48 @@ func createFullPathReference(basefile string, path string) (string, error) {
 
 }
 
-func runCommand(name string, env map[string]string, args ...string) error {
+func runCommand(ctx context.Context, name string, env map[string]string, args ...string) error {
+	_, err := runCommandWithOutput(ctx, name, env, args...)
+	return err
+}
 
-	command := exec.Command(name, args...)
+func runCommandWithOutput(ctx context.Context, name string, env map[string]string, args ...string) ([]byte, error) {
+	command := newCommand(ctx, name, env, args...)
 	fmt.Println("Running command", command.String())
+	return executeCommand(ctx, command)
+}
+
+// queryCommand runs a command only to read its output, unlike the steps of a build it is not announced.
+func queryCommand(ctx context.Context, name string, env map[string]string, args ...string) ([]byte, error) {
+	return executeCommand(ctx, newCommand(ctx, name, env, args...))
+}
+
+func newCommand(ctx context.Context, name string, env map[string]string, args ...string) *exec.Cmd {
+	command := exec.CommandContext(ctx, name, args...)
 	if errors.Is(command.Err, exec.ErrDot) {
 		command.Err = nil
 	}
-	var sdterr bytes.Buffer
-	command.Stderr = &sdterr
-	var sdtout bytes.Buffer
-	command.Stdout = &sdtout
-
 	command.Env = os.Environ()
 	if env != nil {
 		for k, v := range env {
 			command.Env = append(command.Env, fmt.Sprintf("%s=%s", k, v))
 		}
 	}
+	return command
+}
 
+func executeCommand(ctx context.Context, command *exec.Cmd) ([]byte, error) {
+	var sdterr bytes.Buffer
+	command.Stderr = &sdterr
+	var sdtout bytes.Buffer
+	command.Stdout = &sdtout
 	if err := command.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("command %s was aborted: %w", command.String(), ctx.Err())
+		}
 		fmt.Println(sdtout.String())
-		return fmt.Errorf("failed command: %s, %s", command.String(), sdterr.String())
+		return nil, fmt.Errorf("failed command: %s, %s", command.String(), sdterr.String())
 	}
-	return nil
+	return sdtout.Bytes(), nil
 
 }
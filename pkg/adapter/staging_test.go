@@ -0,0 +1,172 @@
+package adapter
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/charmbracelet/log"
+)
+
+func TestJoinFlags(t *testing.T) {
+	tests := []struct {
+		name      string
+		pattern   string
+		arguments []string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "plain",
+			arguments: []string{"-s", "-w", "-X", "main.version=1"},
+			want:      "-s -w -X main.version=1",
+		},
+		{
+			name:      "whitespace",
+			arguments: []string{"-X", "main.greeting=hello world"},
+			want:      "-X 'main.greeting=hello world'",
+		},
+		{
+			name:      "single quote",
+			arguments: []string{"-X", "main.greeting=it's me"},
+			want:      `-X "main.greeting=it's me"`,
+		},
+		{
+			name:      "leading quote",
+			arguments: []string{"-X", `"main.v=1"`},
+			want:      `-X '"main.v=1"'`,
+		},
+		{
+			name:      "inner quote",
+			arguments: []string{"-X", `main.v="1"`},
+			want:      `-X main.v="1"`,
+		},
+		{
+			name:      "empty",
+			arguments: []string{"-X", ""},
+			want:      "-X ''",
+		},
+		{
+			name:      "pattern",
+			pattern:   "all",
+			arguments: []string{"-N", "-l"},
+			want:      "all=-N -l",
+		},
+		{
+			name:      "both quotes",
+			arguments: []string{"-X", `main.v=it's "me"`},
+			wantErr:   true,
+		},
+		{
+			name:      "no leading flag",
+			arguments: []string{"main.greeting=hello world"},
+			wantErr:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := joinFlags(test.pattern, test.arguments)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("joinFlags() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("joinFlags() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStripFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		ldflags []string
+		want    []string
+	}{
+		{
+			name:    "none",
+			ldflags: []string{},
+			want:    []string{"-s", "-w"},
+		},
+		{
+			name:    "partially configured",
+			ldflags: []string{"-w", "-X", "main.v=1"},
+			want:    []string{"-s", "-w", "-X", "main.v=1"},
+		},
+		{
+			name:    "configured",
+			ldflags: []string{"-s", "-w"},
+			want:    []string{"-s", "-w"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := stripFlags(test.ldflags); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("stripFlags() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings BuildSettings
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "nothing",
+			settings: BuildSettings{},
+			want:     []string{},
+		},
+		{
+			name:     "tags",
+			settings: BuildSettings{Tags: []string{"lambda", "extra"}},
+			want:     []string{"-tags", "lambda,extra"},
+		},
+		{
+			name:     "flag with its value in one element",
+			settings: BuildSettings{Ldflags: []string{"-X main.v=1"}},
+			want:     []string{"-ldflags", "-X main.v=1"},
+		},
+		{
+			name:     "value with whitespace in its own element",
+			settings: BuildSettings{Ldflags: []string{"-X", "main.greeting=hello world"}},
+			want:     []string{"-ldflags", "-X 'main.greeting=hello world'"},
+		},
+		{
+			name:     "strip split elements",
+			settings: BuildSettings{Ldflags: []string{"-s -w"}, Strip: true},
+			want:     []string{"-ldflags", "-s -w"},
+		},
+		{
+			name:     "strip and version",
+			settings: BuildSettings{Ldflags: []string{"-X main.v=1"}, Strip: true, VersionVariable: "main.version"},
+			want:     []string{"-ldflags", "-s -w -X main.v=1 -X main.version=v1.2.3"},
+		},
+		{
+			name:     "gcflags pattern",
+			settings: BuildSettings{Gcflags: []string{"all=-N -l"}},
+			want:     []string{"-gcflags", "all=-N -l"},
+		},
+		{
+			name:     "value without a flag",
+			settings: BuildSettings{Ldflags: []string{"main.greeting=hello world"}},
+			wantErr:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := &DefaultStagingAdapter{Logger: log.New(io.Discard)}
+			a.versionOnce.Do(func() { a.version = "v1.2.3" })
+			got, err := a.buildFlags(context.Background(), &test.settings)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("buildFlags() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("buildFlags() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
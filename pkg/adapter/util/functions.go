@@ -45,6 +45,8 @@ var CommandSpecificResourceTypes = []string{
 	"AWS::IAM::Role",
 }
 
+var LambdaFunctionResourceType = WhiteListPredicate([]string{"AWS::Lambda::Function"})
+
 var WhiteListCommandSpecificResourceTypes = WhiteListPredicate(CommandSpecificResourceTypes)
 var BlackListCommandSpecificResourceTypes = BlackListPredicate(CommandSpecificResourceTypes)
 
@@ -95,3 +97,86 @@ func GenerateTagApplicator(tags map[string]string) Applicator {
 		return nil
 	}
 }
+
+func GenerateArchitectureApplicator(architecture string) Applicator {
+	return func(resource map[interface{}]interface{}) error {
+		properties, ok := resource["Properties"].(map[interface{}]interface{})
+		if !ok {
+			properties = make(map[interface{}]interface{})
+			resource["Properties"] = properties
+		}
+		if existing, found := properties["Architectures"]; found {
+			if declared, ok := existing.([]interface{}); ok && len(declared) == 1 && declared[0] == architecture {
+				return nil
+			}
+			return fmt.Errorf("function declares architectures %v but is built for %s", existing, architecture)
+		}
+		properties["Architectures"] = []interface{}{architecture}
+		return nil
+	}
+}
+
+func ReadTag(resource map[interface{}]interface{}, key string) (string, bool) {
+	properties, ok := resource["Properties"].(map[interface{}]interface{})
+	if !ok {
+		return "", false
+	}
+	switch tags := properties["Tags"].(type) {
+	case []interface{}:
+		for _, tagRaw := range tags {
+			if tag, ok := tagRaw.(map[interface{}]interface{}); ok && tag["Key"] == key {
+				value, ok := tag["Value"].(string)
+				return value, ok
+			}
+		}
+	case map[interface{}]interface{}:
+		value, ok := tags[key].(string)
+		return value, ok
+	}
+	return "", false
+}
+
+// GenerateImageApplicator points a function at a container image, zip specific properties do not apply to images.
+func GenerateImageApplicator(imageUri string) Applicator {
+	return func(resource map[interface{}]interface{}) error {
+		properties, ok := resource["Properties"].(map[interface{}]interface{})
+		if !ok {
+			properties = make(map[interface{}]interface{})
+			resource["Properties"] = properties
+		}
+		if packageType, found := properties["PackageType"]; found && packageType != "Image" {
+			return fmt.Errorf("function declares package type %v but is packaged as image", packageType)
+		}
+		properties["PackageType"] = "Image"
+		properties["Code"] = map[interface{}]interface{}{
+			"ImageUri": imageUri,
+		}
+		delete(properties, "Runtime")
+		delete(properties, "Handler")
+		return nil
+	}
+}
+
+// GenerateLayerApplicator appends layer references to the layers a function already declares.
+func GenerateLayerApplicator(layerRefs []interface{}) Applicator {
+	return func(resource map[interface{}]interface{}) error {
+		properties, ok := resource["Properties"].(map[interface{}]interface{})
+		if !ok {
+			properties = make(map[interface{}]interface{})
+			resource["Properties"] = properties
+		}
+		if properties["PackageType"] == "Image" {
+			return fmt.Errorf("functions packaged as image cannot use layers")
+		}
+		layers := make([]interface{}, 0, len(layerRefs))
+		if existing, found := properties["Layers"]; found {
+			declared, ok := existing.([]interface{})
+			if !ok {
+				return fmt.Errorf("could not process layers due to incompatible type %T", existing)
+			}
+			layers = append(layers, declared...)
+		}
+		properties["Layers"] = append(layers, layerRefs...)
+		return nil
+	}
+}
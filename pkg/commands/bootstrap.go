@@ -40,8 +40,8 @@ func (a *DefaultBootstrapActions) CreateCommand() *cli.Command {
 
 }
 
-func NewBootstrapContext(session *Session) (BootstrapContext, error) {
-	cloudformationAdapter, err := adapter.NewCloudFormationAdapter()
+func NewBootstrapContext(ctx context.Context, session *Session) (BootstrapContext, error) {
+	cloudformationAdapter, err := adapter.NewCloudFormationAdapter(ctx, session.StdOut, session.PollInterval)
 	if err != nil {
 		return nil, err
 	}
@@ -54,16 +54,26 @@ func NewBootstrapContext(session *Session) (BootstrapContext, error) {
 
 func (a *DefaultBootstrapActions) Init(cCtx *cli.Context) error {
 	fmt.Println("Will create a new CloudFormation Stack")
-	ctx := context.Background()
+	ctx, cancel := a.Session.Context(cCtx)
+	defer cancel()
+	var conf *config.ApplicationConfig
+	if *a.Session.Environment != "" {
+		var err error
+		conf, err = a.Session.LoadEnvironmentConfig(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	stackName := bootstrapStackName(conf, *a.Session.Environment)
 	tmpl, err := createGadgetTemplate()
 	if err != nil {
 		return err
 	}
-	err = a.CloudformationAdapter.DeployTemplateAsBytes(ctx, "gadget-init", tmpl)
+	err = a.CloudformationAdapter.DeployTemplateAsBytes(ctx, stackName, tmpl)
 	if err != nil {
 		return err
 	}
-	templateAsBytes, err := a.CloudformationAdapter.LoadTemplate(ctx, "gadget-init")
+	templateAsBytes, err := a.CloudformationAdapter.LoadTemplate(ctx, stackName)
 	if err != nil {
 		return err
 	}
@@ -71,11 +81,20 @@ func (a *DefaultBootstrapActions) Init(cCtx *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	return a.Session.SaveBootstrapConfig(&config.Bootstrap{
+	return a.Session.SaveBootstrapConfig(conf, &config.Bootstrap{
 		DeploymentConfig: *cfg,
 	})
 }
 
+// bootstrapStackName gives an environment with its own bootstrap config its own stack, so it gets its own bucket
+// even if it shares the account with other environments.
+func bootstrapStackName(conf *config.ApplicationConfig, environment string) string {
+	if conf != nil && conf.BootstrapOf(environment) != nil {
+		return "gadget-init-" + environment
+	}
+	return "gadget-init"
+}
+
 func generateBootstrap(ctx context.Context, templateAsBytes []byte) (*config.DeploymentConfig, error) {
 	template, err := goformation.ParseYAML(templateAsBytes)
 	if err != nil {
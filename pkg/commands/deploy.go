@@ -2,7 +2,14 @@ package commands
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/stefan79/gadget-cli/pkg/adapter"
@@ -17,6 +24,7 @@ type (
 		CloudFormationAdapter   adapter.CloudFormationAdapter
 		GadgetoFormationAdapter adapter.GadgetoFormationAdapter
 		StagingAdapter          adapter.StagingAdapter
+		RegistryAdapter         adapter.RegistryAdapter
 		BootStrap               *config.Bootstrap
 		ApplicationConfig       *config.ApplicationConfig
 	}
@@ -25,168 +33,743 @@ type (
 		Deploy(cCtx *cli.Context) error
 	}
 
+	SynthActions interface {
+		Synth(cCtx *cli.Context) error
+	}
+
 	DeployContext interface {
 		CommandBuilder
 		DeployActions
+		SynthActions
+		CreateSynthCommand() *cli.Command
 	}
 )
 
-func NewDeployContext(session *Session) (DeployContext, error) {
-	applicationConfig, err := session.LoadApplicationConfig()
-	if err != nil {
-		return nil, err
+func NewDeployContext(session *Session) DeployContext {
+	return &DefaultDeployActions{
+		Session: session,
+	}
+}
+
+// load reads the application config and creates the adapters once a command runs and its flags are parsed.
+func (a *DefaultDeployActions) load(ctx context.Context) error {
+	if a.ApplicationConfig != nil {
+		return nil
 	}
-	stagingAdapter, err := adapter.NewStagingAdapter(applicationConfig.Name, session.StagingPath)
+	session := a.Session
+	applicationConfig, err := session.LoadEnvironmentConfig(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	s3Adapter, err := adapter.NewS3Adapter()
+	stagingAdapter, err := adapter.NewStagingAdapter(applicationConfig.Name, session.StagingPath, session.WorkPath, session.NoCache, session.GeneratorTimeout, session.StdOut)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	bootstrap, err := session.LoadBootstrapConfig()
+	s3Adapter, err := adapter.NewS3Adapter(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	cloudformationAdapter, err := adapter.NewCloudFormationAdapter()
+	cloudformationAdapter, err := adapter.NewCloudFormationAdapter(ctx, session.StdOut, session.PollInterval)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	gadgetoFormationAdapter, err := adapter.NewGadgetoFormationAdapter(applicationConfig.Name, applicationConfig.Tags, session.StagingPath)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return &DefaultDeployActions{
-		Session:                 session,
-		StagingAdapter:          stagingAdapter,
-		S3Adapter:               s3Adapter,
-		BootStrap:               bootstrap,
-		CloudFormationAdapter:   cloudformationAdapter,
-		GadgetoFormationAdapter: gadgetoFormationAdapter,
-		ApplicationConfig:       applicationConfig,
-	}, nil
+	registryAdapter, err := adapter.NewRegistryAdapter(session.RegistryUsername, session.RegistryPassword, session.RegistryInsecure, session.StdOut)
+	if err != nil {
+		return err
+	}
+	a.StagingAdapter = stagingAdapter
+	a.S3Adapter = s3Adapter
+	a.CloudFormationAdapter = cloudformationAdapter
+	a.GadgetoFormationAdapter = gadgetoFormationAdapter
+	a.RegistryAdapter = registryAdapter
+	a.ApplicationConfig = applicationConfig
+	return nil
+}
+
+func (a *DefaultDeployActions) loadBootstrap() (*config.Bootstrap, error) {
+	if a.BootStrap != nil {
+		return a.BootStrap, nil
+	}
+	bootstrap, err := a.Session.LoadBootstrapConfig(a.ApplicationConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error loading bootstrap config, did you run gadget bootstrap?: %w", err)
+	}
+	a.BootStrap = bootstrap
+	return bootstrap, nil
 }
 
 func (a *DefaultDeployActions) Deploy(cCtx *cli.Context) error {
-	ctx := context.Background()
+	if cCtx.Bool("dry-run") {
+		return a.Synth(cCtx)
+	}
+	ctx, cancel := a.Session.Context(cCtx)
+	defer cancel()
+	if err := a.load(ctx); err != nil {
+		return err
+	}
+	bootstrap, err := a.loadBootstrap()
+	if err != nil {
+		return err
+	}
 	a.Session.StdOut.Info("Deploying application", "appication", *a.ApplicationConfig.Name)
-	for _, command := range a.ApplicationConfig.Commands {
-		param := prepareCmdDeploymentParam{
-			cmdName:        *command.Name,
-			srcFile:        *command.Path,
-			bucketName:     *a.BootStrap.S3BucketName,
-			stagingAdapter: a.StagingAdapter,
-			s3Adapter:      a.S3Adapter,
-		}
-		a.Session.StdOut.Info("Deploying command", "command", *command.Name)
-		cloudformationTemplate, err := prepareCmdDeployment(ctx, param, *a.Session.StdOut)
+	fullTemplateName, manifest, err := a.buildApplication(ctx, buildOptions{
+		bucketName:  *bootstrap.S3BucketName,
+		parallelism: cCtx.Int("parallelism"),
+		push:        cCtx.Bool("push"),
+	})
+	if err != nil {
+		return err
+	}
+	if !cCtx.Bool("push") {
+		if err := a.verifyImagesPushed(ctx, manifest); err != nil {
+			return err
+		}
+	}
+	status, err := a.prepareStack(ctx, cCtx, *a.ApplicationConfig.Name)
+	if err != nil {
+		return err
+	}
+	// Read before the change set replaces the deployed template
+	deployedSizes := a.deployedArchiveSizes(ctx, status, *bootstrap.S3BucketName)
+	a.Session.StdOut.Debug("Creating change set", "templateName", *fullTemplateName, "create", status.RequiresCreate())
+	plan, err := a.CloudFormationAdapter.CreateChangeSetAsFile(ctx, *a.ApplicationConfig.Name, *fullTemplateName, a.ApplicationConfig.Parameters, status.RequiresCreate())
+	if err != nil {
+		return fmt.Errorf("error creating change set: %w", err)
+	}
+	if plan.Empty {
+		a.Session.StdOut.Info("Stack up to date", "stackName", *a.ApplicationConfig.Name)
+		a.summarizeArtifacts(manifest, deployedSizes)
+		return a.CloudFormationAdapter.DeleteChangeSet(ctx, plan)
+	}
+	renderChangeSetPlan(a.Session.StdOut, plan)
+	if !cCtx.Bool("yes") {
+		confirmed, err := a.Session.Confirm("Execute change set?")
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			a.Session.StdOut.Info("Discarding change set", "changeSet", plan.ChangeSetName)
+			return a.CloudFormationAdapter.DeleteChangeSet(ctx, plan)
+		}
+	}
+	a.Session.StdOut.Info("Executing change set", "changeSet", plan.ChangeSetName)
+	err = a.CloudFormationAdapter.ExecuteChangeSet(ctx, plan)
+	if err != nil {
+		if ctx.Err() != nil {
+			a.cancelUpdate(ctx, cCtx, plan)
+		}
+		return a.diagnose(err)
+	}
+	a.summarizeArtifacts(manifest, deployedSizes)
+	return nil
+}
+
+// verifyImagesPushed fails before the change set if an image was not pushed, CloudFormation would only fail
+// once it updates the function.
+func (a *DefaultDeployActions) verifyImagesPushed(ctx context.Context, manifest *adapter.ArtifactManifest) error {
+	errs := make([]error, 0)
+	for _, artifact := range manifest.Artifacts {
+		if artifact.PackageType != config.PackageTypeImage {
+			continue
+		}
+		found, err := a.RegistryAdapter.HasImage(ctx, artifact.ImageUri)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not check the image of command %s: %w", artifact.Command, err))
+			continue
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("image %s of command %s is not in the registry, run gadget push or pass --push", artifact.ImageUri, artifact.Command))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cancelUpdate rolls back an interrupted update if requested, the stack would otherwise keep on updating unattended.
+func (a *DefaultDeployActions) cancelUpdate(ctx context.Context, cCtx *cli.Context, plan *adapter.ChangeSetPlan) {
+	if !cCtx.Bool("cancel-on-interrupt") {
+		a.Session.StdOut.Warn("Deployment interrupted, the stack operation continues in the background", "stackName", plan.StackName)
+		return
+	}
+	if plan.Create {
+		a.Session.StdOut.Warn("Stack creation cannot be cancelled, it continues in the background", "stackName", plan.StackName)
+		return
+	}
+	cancelCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+	defer cancel()
+	a.Session.StdOut.Warn("Deployment interrupted, cancelling stack update", "stackName", plan.StackName)
+	if err := a.CloudFormationAdapter.CancelUpdate(cancelCtx, plan.StackName); err != nil {
+		a.Session.StdOut.Error("Could not cancel stack update", "stackName", plan.StackName, "err", err)
+	}
+}
+
+func (a *DefaultDeployActions) summarizeArtifacts(manifest *adapter.ArtifactManifest, deployedSizes map[string]int64) {
+	for _, artifact := range manifest.Artifacts {
+		if artifact.PackageType == config.PackageTypeImage {
+			a.Session.StdOut.Info("Artifact", "command", artifact.Command, "image", artifact.ImageUri, "pushed", artifact.Uploaded, "binary", config.FormatSize(artifact.BinarySize))
+			continue
+		}
+		sizes := []interface{}{"binary", config.FormatSize(artifact.BinarySize), "package", config.FormatSize(artifact.ArchiveSize)}
+		if deployedSize, found := deployedSizes[artifact.Command]; found {
+			delta := config.FormatSize(artifact.ArchiveSize - deployedSize)
+			if artifact.ArchiveSize >= deployedSize {
+				delta = "+" + delta
+			}
+			sizes = append(sizes, "delta", delta)
+		}
+		a.Session.StdOut.Info("Artifact", append([]interface{}{"command", artifact.Command, "key", artifact.Key, "uploaded", artifact.Uploaded}, sizes...)...)
+	}
+	for _, layer := range manifest.Layers {
+		a.Session.StdOut.Info("Layer", "layer", layer.Layer, "key", layer.Key, "uploaded", layer.Uploaded)
+	}
+}
+
+// deployedArchiveSizes looks up the size of the zip every command currently runs, it only feeds the summary,
+// so a failing lookup is logged and skipped.
+func (a *DefaultDeployActions) deployedArchiveSizes(ctx context.Context, status *adapter.DeploymentStatus, bucketName string) map[string]int64 {
+	sizes := make(map[string]int64)
+	if status.RequiresCreate() {
+		return sizes
+	}
+	data, err := a.CloudFormationAdapter.LoadTemplate(ctx, *a.ApplicationConfig.Name)
+	if err != nil {
+		a.Session.StdOut.Debug("Could not load deployed template", "err", err)
+		return sizes
+	}
+	functions, err := adapter.ReadDeployedFunctions(data)
+	if err != nil {
+		a.Session.StdOut.Debug("Could not read deployed functions", "err", err)
+		return sizes
+	}
+	for _, function := range functions {
+		if function.S3Key == "" {
+			continue
+		}
+		info, err := a.S3Adapter.HeadFile(ctx, bucketName, function.S3Key)
+		if err != nil || info == nil {
+			a.Session.StdOut.Debug("Could not read deployed artifact", "command", function.Command, "key", function.S3Key, "err", err)
+			continue
+		}
+		sizes[function.Command] = info.Size
+	}
+	return sizes
+}
+
+// prepareStack brings the stack into a state which accepts a change set, depending on the phase of its current status.
+func (a *DefaultDeployActions) prepareStack(ctx context.Context, cCtx *cli.Context, stackName string) (*adapter.DeploymentStatus, error) {
+	for {
+		a.Session.StdOut.Debug("Checking Deployment Status", "stackName", stackName)
+		status, err := a.CloudFormationAdapter.GetDeploymentStatus(ctx, stackName)
 		if err != nil {
-			return fmt.Errorf("error preparing command deployment: %w", err)
+			return nil, fmt.Errorf("error getting deployment status: %w", err)
 		}
+		a.Session.StdOut.Debug("Deployment Status", "status", status.Status, "phase", status.Phase, "successful", status.Successful)
+		switch status.Phase {
+		case adapter.StackPhaseNotFound, adapter.StackPhaseReview, adapter.StackPhaseStable:
+			return status, nil
+		case adapter.StackPhaseInProgress:
+			a.Session.StdOut.Info("Waiting for running stack operation", "stackName", stackName, "status", status.Status)
+			_, err = a.CloudFormationAdapter.WaitForStableStatus(ctx, stackName)
+		case adapter.StackPhaseRecreate:
+			a.Session.StdOut.Warn("Stack cannot be updated and has to be recreated", "stackName", stackName, "status", status.Status)
+			err = a.confirm(cCtx, fmt.Sprintf("Delete stack %s and create it again?", stackName))
+			if err == nil {
+				err = a.CloudFormationAdapter.DeleteStack(ctx, stackName)
+			}
+		case adapter.StackPhaseUpdateRollbackFailed:
+			a.Session.StdOut.Warn("Stack update rollback failed", "stackName", stackName, "status", status.Status)
+			err = a.confirm(cCtx, fmt.Sprintf("Continue the update rollback of stack %s?", stackName))
+			if err == nil {
+				err = a.diagnose(a.CloudFormationAdapter.ContinueUpdateRollback(ctx, stackName))
+			}
+		default:
+			return nil, fmt.Errorf("stack %s is in status %s and needs manual intervention", stackName, status.Status)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// confirm asks the user unless --yes was passed, declining aborts the deployment.
+func (a *DefaultDeployActions) confirm(cCtx *cli.Context, question string) error {
+	if cCtx.Bool("yes") {
+		return nil
+	}
+	confirmed, err := a.Session.Confirm(question)
+	if err != nil {
+		return err
+	}
+	if !confirmed {
+		return fmt.Errorf("deployment aborted")
+	}
+	return nil
+}
+
+// diagnose attributes the failing resources of a stack operation to the commands which contributed them.
+func (a *DefaultDeployActions) diagnose(err error) error {
+	var stackErr *adapter.StackOperationError
+	if !errors.As(err, &stackErr) {
+		return err
+	}
+	stackErr.AttributeCommands(a.GadgetoFormationAdapter.ResourceOwners())
+	for _, failure := range stackErr.RootCauses() {
+		a.Session.StdOut.Error("Resource failed",
+			"command", failure.Command,
+			"resource", failure.LogicalId,
+			"type", failure.ResourceType,
+			"status", failure.Status,
+			"reason", failure.Reason,
+		)
+	}
+	return stackErr
+}
+
+func (a *DefaultDeployActions) Synth(cCtx *cli.Context) error {
+	ctx, cancel := a.Session.Context(cCtx)
+	defer cancel()
+	if err := a.load(ctx); err != nil {
+		return err
+	}
+	bucketName := synthBucketName
+	if bootstrap, err := a.loadBootstrap(); err == nil {
+		bucketName = *bootstrap.S3BucketName
+	} else {
+		a.Session.StdOut.Warn("No bootstrap config found, using placeholder bucket", "bucket", bucketName)
+	}
+	a.Session.StdOut.Info("Synthesizing application", "appication", *a.ApplicationConfig.Name)
+	fullTemplateName, _, err := a.buildApplication(ctx, buildOptions{
+		bucketName:           bucketName,
+		dryRun:               true,
+		parallelism:          cCtx.Int("parallelism"),
+		compareArchitectures: cCtx.Bool("compare-architectures"),
+	})
+	if err != nil {
+		return err
+	}
+	a.Session.StdOut.Info("Synthesized application template", "template", *fullTemplateName)
+	return nil
+}
+
+func (a *DefaultDeployActions) buildApplication(ctx context.Context, options buildOptions) (*string, *adapter.ArtifactManifest, error) {
+	if err := a.validateLayers(); err != nil {
+		return nil, nil, err
+	}
+	artifacts, err := a.prepareCommands(ctx, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Layers are added first, the functions of the commands reference them
+	layers, err := a.prepareLayers(ctx, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest := &adapter.ArtifactManifest{
+		Application: *a.ApplicationConfig.Name,
+		Artifacts:   artifacts,
+		Layers:      layers,
+	}
+	// Merge in configuration order, independent of which build finished first
+	for i, command := range a.ApplicationConfig.Commands {
 		a.Session.StdOut.Debug("Merging command template", "command", *command.Name)
-		err = a.GadgetoFormationAdapter.MergeCommandTemplate(command.Name, command.Path, cloudformationTemplate)
+		err = a.GadgetoFormationAdapter.MergeCommandTemplate(command.Name, command.Path, &artifacts[i].Template, &adapter.CommandTemplateOptions{
+			Architecture: artifacts[i].Architecture,
+			ImageUri:     artifacts[i].ImageUri,
+			Layers:       command.Layers,
+		})
 		if err != nil {
-			return fmt.Errorf("error merging command template: %w", err)
+			return nil, nil, fmt.Errorf("error merging command template: %w", err)
 		}
 	}
 
 	templateName := "cloudformation.yaml"
 	a.Session.StdOut.Debug("Saving application template", "templateName", templateName)
-	err := a.GadgetoFormationAdapter.SaveApplicationTemplate(&templateName)
+	err = a.GadgetoFormationAdapter.SaveApplicationTemplate(&templateName)
 	if err != nil {
-		return fmt.Errorf("error saving application template: %w", err)
+		return nil, nil, fmt.Errorf("error saving application template: %w", err)
 	}
-	fullTemplateName, err := a.StagingAdapter.GetFileFromStaging(&templateName)
+	// CloudFormation would only report mismatching parameters as an opaque validation error of the change set
+	err = a.GadgetoFormationAdapter.CheckParameters(a.ApplicationConfig.Parameters)
 	if err != nil {
-		return err
+		return nil, nil, fmt.Errorf("error checking parameters: %w", err)
 	}
-	a.Session.StdOut.Debug("Checking Deployment Status", "stackName", *a.ApplicationConfig.Name)
-	status, err := a.CloudFormationAdapter.GetDeploymentStatus(ctx, *a.ApplicationConfig.Name)
+	manifestName := a.Session.ArtifactManifestName()
+	a.Session.StdOut.Debug("Saving artifact manifest", "manifestName", manifestName)
+	err = a.StagingAdapter.SaveArtifactManifest(&manifestName, manifest)
 	if err != nil {
-		return fmt.Errorf("error getting deployment status: %w", err)
+		return nil, nil, fmt.Errorf("error saving artifact manifest: %w", err)
 	}
-	a.Session.StdOut.Debug("Deployment Status", "status", status.Status, "found", status.Found, "successful", status.Found)
-	if status.Found {
-		a.Session.StdOut.Debug("Updating application template", "templateName", *fullTemplateName)
-		return a.CloudFormationAdapter.UpdateTemplateAsFile(ctx, *a.ApplicationConfig.Name, *fullTemplateName)
-	} else {
-		a.Session.StdOut.Debug("Deploying application template", "templateName", *fullTemplateName)
-		return a.CloudFormationAdapter.DeployTemplateAsFile(ctx, *a.ApplicationConfig.Name, *fullTemplateName)
+	fullTemplateName, err := a.StagingAdapter.GetFileFromStaging(&templateName)
+	if err != nil {
+		return nil, nil, err
 	}
+	return fullTemplateName, manifest, nil
+}
+
+func (a *DefaultDeployActions) prepareCommands(ctx context.Context, options buildOptions) ([]*adapter.Artifact, error) {
+	commands := a.ApplicationConfig.Commands
+	parallelism := options.parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	artifacts := make([]*adapter.Artifact, len(commands))
+	errs := make([]error, len(commands))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < parallelism; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				command := commands[i]
+				if err := ctx.Err(); err != nil {
+					errs[i] = fmt.Errorf("command %s: %w", *command.Name, err)
+					continue
+				}
+				architecture, err := a.ApplicationConfig.ArchitectureOf(command)
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				packageType, err := command.PackageTypeOf()
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				maxSize, err := command.MaxSizeOf()
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				param := prepareCmdDeploymentParam{
+					appName:              *a.ApplicationConfig.Name,
+					cmdName:              *command.Name,
+					srcFile:              *command.Path,
+					cmdDir:               command.Dir(),
+					assets:               command.Assets,
+					buildSettings:        buildSettingsOf(command),
+					packageType:          packageType,
+					baseImage:            stringOrEmpty(command.BaseImage),
+					imageRepository:      stringOrEmpty(a.ApplicationConfig.ImageRepository),
+					push:                 options.push,
+					maxSize:              maxSize,
+					bucketName:           options.bucketName,
+					architecture:         architecture,
+					passArchitecture:     a.ApplicationConfig.HasExplicitArchitecture(command),
+					compareArchitectures: options.compareArchitectures,
+					dryRun:               options.dryRun,
+					stagingAdapter:       a.StagingAdapter,
+					s3Adapter:            a.S3Adapter,
+					registryAdapter:      a.RegistryAdapter,
+				}
+				logger := a.Session.StdOut.With("command", *command.Name)
+				logger.Info("Preparing command")
+				artifacts[i], errs[i] = prepareCmdDeployment(ctx, param, logger)
+				if errs[i] != nil {
+					errs[i] = fmt.Errorf("command %s: %w", *command.Name, errs[i])
+				}
+			}
+		}()
+	}
+	for i := range commands {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("error preparing command deployment: %w", err)
+	}
+	return artifacts, nil
+}
+
+// artifactKey addresses an artifact by its checksum, so changed code always results in a new key.
+func artifactKey(appName string, cmdName string, checksum string) string {
+	return fmt.Sprintf("%s/%s/%s.zip", appName, cmdName, checksum)
+}
+
+func checksumFromKey(key string) string {
+	return strings.TrimSuffix(path.Base(key), ".zip")
+}
+
+func checksumFromImageUri(imageUri string) string {
+	_, digest, _ := strings.Cut(imageUri, "@")
+	return strings.TrimPrefix(digest, "sha256:")
+}
+
+func buildSettingsOf(command *config.Command) *adapter.BuildSettings {
+	settings := &adapter.BuildSettings{
+		Tags:    command.BuildTags,
+		Ldflags: command.Ldflags,
+		Gcflags: command.Gcflags,
+		Cgo:     command.Cgo,
+		Env:     command.Env,
+		Strip:   command.StripOf(),
+	}
+	if command.VersionVariable != nil {
+		settings.VersionVariable = *command.VersionVariable
+	}
+	return settings
+}
+
+func stringOrEmpty(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// goArchitectures maps Lambda architectures to their GOARCH.
+var goArchitectures = map[string]string{
+	config.ArchitectureX86_64: "amd64",
+	config.ArchitectureArm64:  "arm64",
+}
+
+// synthBucketName is handed to the command templates when synthesizing without a bootstrapped account.
+const synthBucketName = "gadget-synth-placeholder"
+
+// synthImageRepository is referenced by image commands when synthesizing without a configured repository.
+const synthImageRepository = "registry.invalid/gadget-synth-placeholder"
+
+type buildOptions struct {
+	bucketName           string
+	dryRun               bool
+	parallelism          int
+	compareArchitectures bool
+	push                 bool
 }
 
 type prepareCmdDeploymentParam struct {
-	cmdName        string
-	srcFile        string
-	bucketName     string
-	stagingAdapter adapter.StagingAdapter
-	s3Adapter      adapter.S3Adapter
+	appName              string
+	cmdName              string
+	srcFile              string
+	cmdDir               string
+	assets               []string
+	buildSettings        *adapter.BuildSettings
+	packageType          string
+	baseImage            string
+	imageRepository      string
+	push                 bool
+	maxSize              int64
+	bucketName           string
+	architecture         string
+	passArchitecture     bool
+	compareArchitectures bool
+	dryRun               bool
+	stagingAdapter       adapter.StagingAdapter
+	s3Adapter            adapter.S3Adapter
+	registryAdapter      adapter.RegistryAdapter
 }
 
-func prepareCmdDeployment(ctx context.Context, param prepareCmdDeploymentParam, logger log.Logger) (*string, error) {
+func prepareCmdDeployment(ctx context.Context, param prepareCmdDeploymentParam, logger *log.Logger) (*adapter.Artifact, error) {
 	inputSource := param.srcFile
 	compiledCommand := param.cmdName + "_local"
-	logger.Debug("Compiling command", "command", param.cmdName)
-	err := param.stagingAdapter.Compile(&inputSource, &compiledCommand)
+	logger.Debug("Compiling command")
+	err := param.stagingAdapter.Compile(ctx, &inputSource, &compiledCommand, param.buildSettings)
 	if err != nil {
 		return nil, err
 	}
 	xcompiledCommand := param.cmdName
-	options := make(map[string]string)
-	options["GOOS"] = "linux"
-	options["GOARCH"] = "amd64"
-	logger.Debug("Cross compiling command", "command", xcompiledCommand)
-	err = param.stagingAdapter.CompileWithOptions(&inputSource, &xcompiledCommand, options)
+	logger.Debug("Cross compiling command", "architecture", param.architecture)
+	err = crossCompile(ctx, param, &xcompiledCommand, param.architecture)
 	if err != nil {
 		return nil, err
 	}
+	if param.compareArchitectures {
+		err = compareArchitectures(ctx, param, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
 	fullxcompiledCommand, err := param.stagingAdapter.GetFileFromStaging(&xcompiledCommand)
 	if err != nil {
 		return nil, err
 	}
-	zipFileName := param.cmdName + ".zip"
-	logger.Debug("Zipping command", "zipfile", zipFileName)
-	err = param.stagingAdapter.Zip(fullxcompiledCommand, &zipFileName)
+	binaryInfo, err := os.Stat(*fullxcompiledCommand)
 	if err != nil {
 		return nil, err
 	}
-	fullZipFileName, err := param.stagingAdapter.GetFileFromStaging(&zipFileName)
-	//_, err = param.stagingAdapter.GetFileFromStaging(&zipFileName)
+	if param.maxSize > 0 && binaryInfo.Size() > param.maxSize {
+		return nil, fmt.Errorf("binary has %d bytes (%s), its maxSize allows %d bytes", binaryInfo.Size(), config.FormatSize(binaryInfo.Size()), param.maxSize)
+	}
+	assets, err := param.stagingAdapter.ResolveAssets(param.cmdDir, param.assets)
+	if err != nil {
+		return nil, err
+	}
+	artifact := &adapter.Artifact{
+		Command:      param.cmdName,
+		Architecture: param.architecture,
+		PackageType:  param.packageType,
+		BinarySize:   binaryInfo.Size(),
+	}
+	if param.packageType == config.PackageTypeImage {
+		err = packageImage(ctx, param, fullxcompiledCommand, assets, artifact, logger)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err = packageZip(ctx, param, fullxcompiledCommand, assets, artifact, logger)
+		if err != nil {
+			return nil, err
+		}
+		// Only zips are measured, the layout of an image contains its base image as well
+		packageInfo, err := os.Stat(artifact.Archive)
+		if err != nil {
+			return nil, err
+		}
+		artifact.ArchiveSize = packageInfo.Size()
+		logger.Debug("Package sizes", "binary", config.FormatSize(artifact.BinarySize), "package", config.FormatSize(artifact.ArchiveSize))
+	}
+
+	cloudformationName := param.cmdName + "_cf.yaml"
+	fullCompiledCommand, err := param.stagingAdapter.GetFileFromStaging(&compiledCommand)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("Generating command template", "template", cloudformationName)
+	var architecture *string
+	if param.passArchitecture {
+		architecture = &param.architecture
+	}
+	bucketName, bucketKey, imageUri := &artifact.Bucket, &artifact.Key, (*string)(nil)
+	if artifact.ImageUri != "" {
+		bucketName, bucketKey, imageUri = nil, nil, &artifact.ImageUri
+	}
+	err = param.stagingAdapter.GenerateTemplate(ctx, fullCompiledCommand, &cloudformationName, &xcompiledCommand, bucketName, bucketKey, architecture, imageUri)
+	if err != nil {
+		return nil, err
+	}
+	fullCloudformationName, err := param.stagingAdapter.GetFileFromStaging(&cloudformationName)
 	if err != nil {
 		return nil, err
 	}
+	artifact.Template = *fullCloudformationName
+	return artifact, nil
+}
 
-	checksum, err := param.stagingAdapter.CalculateCheckSum(&xcompiledCommand)
+func packageZip(ctx context.Context, param prepareCmdDeploymentParam, binary *string, assets []adapter.Asset, artifact *adapter.Artifact, logger *log.Logger) error {
+	zipFileName := param.cmdName + ".zip"
+	logger.Debug("Zipping command", "zipfile", zipFileName, "assets", len(assets))
+	err := param.stagingAdapter.Zip(binary, assets, &zipFileName)
 	if err != nil {
-		return nil, fmt.Errorf("error calculating checksum: %w", err)
+		return err
+	}
+	fullZipFileName, err := param.stagingAdapter.GetFileFromStaging(&zipFileName)
+	if err != nil {
+		return err
+	}
+
+	checksum, err := param.stagingAdapter.CalculateCheckSum(&zipFileName)
+	if err != nil {
+		return fmt.Errorf("error calculating checksum: %w", err)
 	}
 	logger.Debug("Generate ZIP checksum", "checksum", checksum)
-	bucketKey := param.cmdName + "/bootstrap.zip"
-	logger.Debug("Uploading Checksum")
-	err = param.s3Adapter.CreateFile(ctx, checksum, param.bucketName, bucketKey+".sha256")
+	bucketKey := artifactKey(param.appName, param.cmdName, checksum)
+	artifact.Archive = *fullZipFileName
+	artifact.Bucket = param.bucketName
+	artifact.Checksum = checksum
+	artifact.Key = bucketKey
+	if param.dryRun {
+		logger.Debug("Skipping upload in dry run", "bucket", param.bucketName, "key", bucketKey)
+		return nil
+	}
+	existing, err := param.s3Adapter.HeadFile(ctx, param.bucketName, bucketKey)
 	if err != nil {
-		return nil, fmt.Errorf("error uploading checksum file %s to bucket %s: %w", checksum, param.bucketName, err)
+		return fmt.Errorf("error checking for artifact %s in bucket %s: %w", bucketKey, param.bucketName, err)
+	}
+	if existing != nil {
+		logger.Debug("Artifact unchanged, skipping upload", "bucket", param.bucketName, "key", bucketKey)
+		return nil
 	}
 	logger.Debug("Uploading command", "bucket", param.bucketName, "key", bucketKey)
 	err = param.s3Adapter.UploadFile(ctx, *fullZipFileName, param.bucketName, bucketKey)
 	if err != nil {
-		return nil, fmt.Errorf("error uploading file %s to bucket %s: %w", *fullZipFileName, param.bucketName, err)
+		return fmt.Errorf("error uploading file %s to bucket %s: %w", *fullZipFileName, param.bucketName, err)
 	}
+	artifact.Uploaded = true
 	//TODO: Replace region!!!
 	s3Url := fmt.Sprintf("https://%s.s3.eu-central-1.amazonaws.com/%s", param.bucketName, bucketKey)
 	logger.Debug("Uploaded to S3", "url", s3Url)
-	cloudformationName := param.cmdName + "_cf.yaml"
-	fullCompiledCommand, err := param.stagingAdapter.GetFileFromStaging(&compiledCommand)
+	return nil
+}
+
+// packageImage builds the container image of a command, it is only pushed when requested.
+func packageImage(ctx context.Context, param prepareCmdDeploymentParam, binary *string, assets []adapter.Asset, artifact *adapter.Artifact, logger *log.Logger) error {
+	repository := param.imageRepository
+	if repository == "" {
+		if !param.dryRun {
+			return fmt.Errorf("command is packaged as image but the application has no imageRepository")
+		}
+		repository = synthImageRepository
+	}
+	imageFileName := param.cmdName + ".tar"
+	logger.Debug("Building image", "image", imageFileName, "assets", len(assets))
+	digest, err := param.stagingAdapter.Image(binary, assets, &adapter.ImageOptions{
+		BaseImage:    param.baseImage,
+		Architecture: goArchitectures[param.architecture],
+		Tag:          param.cmdName,
+	}, &imageFileName)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	logger.Debug("Generating command template", "template", cloudformationName)
-	err = param.stagingAdapter.GenerateTemplate(fullCompiledCommand, &cloudformationName, &xcompiledCommand, &param.bucketName, &bucketKey)
+	fullImageFileName, err := param.stagingAdapter.GetFileFromStaging(&imageFileName)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return param.stagingAdapter.GetFileFromStaging(&cloudformationName)
+	checksum := strings.TrimPrefix(digest, "sha256:")
+	logger.Debug("Generate image digest", "digest", digest)
+	artifact.Image = *fullImageFileName
+	artifact.Checksum = checksum
+	artifact.ImageUri = adapter.ImageUri(repository, digest)
+	artifact.ImageTag = imageTag(param.cmdName, checksum)
+	if !param.push {
+		return nil
+	}
+	logger.Debug("Pushing image", "repository", repository, "tag", artifact.ImageTag)
+	artifact.Uploaded, err = param.registryAdapter.PushImage(ctx, artifact.Image, repository, artifact.ImageTag)
+	if err != nil {
+		return fmt.Errorf("error pushing image to %s: %w", repository, err)
+	}
+	return nil
+}
+
+// imageTag names a pushed image after its command, the digest keeps the tag unique per content.
+func imageTag(cmdName string, checksum string) string {
+	return fmt.Sprintf("%s-%s", cmdName, checksum[:12])
+}
+
+func crossCompile(ctx context.Context, param prepareCmdDeploymentParam, outputTarget *string, architecture string) error {
+	options := make(map[string]string)
+	options["GOOS"] = "linux"
+	options["GOARCH"] = goArchitectures[architecture]
+	inputSource := param.srcFile
+	return param.stagingAdapter.CompileWithOptions(ctx, &inputSource, outputTarget, options, param.buildSettings)
+}
 
+// compareArchitectures builds the command for every supported architecture and reports the binary sizes.
+func compareArchitectures(ctx context.Context, param prepareCmdDeploymentParam, logger *log.Logger) error {
+	keyvals := make([]interface{}, 0, 2*len(config.Architectures))
+	for _, architecture := range config.Architectures {
+		compiledCommand := param.cmdName + "_" + architecture
+		err := crossCompile(ctx, param, &compiledCommand, architecture)
+		if err != nil {
+			return err
+		}
+		fullCompiledCommand, err := param.stagingAdapter.GetFileFromStaging(&compiledCommand)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(*fullCompiledCommand)
+		if err != nil {
+			return err
+		}
+		keyvals = append(keyvals, architecture, info.Size())
+	}
+	logger.Info("Architecture comparison", keyvals...)
+	return nil
 }
 
 func (a *DefaultDeployActions) CreateCommand() *cli.Command {
@@ -194,5 +777,57 @@ func (a *DefaultDeployActions) CreateCommand() *cli.Command {
 		Name:   "deploy",
 		Usage:  "Deploys the workspace / individual commands to AWS",
 		Action: a.Deploy,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "yes",
+				Usage: "execute the change set without asking for confirmation",
+			},
+			&cli.BoolFlag{
+				Name:  "cancel-on-interrupt",
+				Usage: "cancel a running stack update when the deployment is interrupted or times out",
+			},
+			&cli.BoolFlag{
+				Name:  "push",
+				Usage: "push the images of commands packaged as image to the image repository",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "build and synthesize the application template without touching AWS",
+			},
+			parallelismFlag(),
+			noCacheFlag(a.Session),
+		},
+	}
+}
+
+func (a *DefaultDeployActions) CreateSynthCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "synth",
+		Usage:  "Builds the workspace and writes the application template to staging without touching AWS",
+		Action: a.Synth,
+		Flags: []cli.Flag{
+			parallelismFlag(),
+			noCacheFlag(a.Session),
+			&cli.BoolFlag{
+				Name:  "compare-architectures",
+				Usage: "additionally build every command for all supported architectures and report the binary sizes",
+			},
+		},
+	}
+}
+
+func noCacheFlag(session *Session) cli.Flag {
+	return &cli.BoolFlag{
+		Name:        "no-cache",
+		Usage:       "ignore the build cache and rebuild every command",
+		Destination: session.NoCache,
+	}
+}
+
+func parallelismFlag() cli.Flag {
+	return &cli.IntFlag{
+		Name:  "parallelism",
+		Usage: "number of commands to build and upload concurrently",
+		Value: runtime.NumCPU(),
 	}
 }
@@ -0,0 +1,130 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/stefan79/gadget-cli/pkg/adapter"
+	"github.com/stefan79/gadget-cli/pkg/config"
+	"github.com/urfave/cli/v2"
+)
+
+type (
+	DefaultDestroyActions struct {
+		Session               *Session
+		S3Adapter             adapter.S3Adapter
+		CloudFormationAdapter adapter.CloudFormationAdapter
+		ApplicationConfig     *config.ApplicationConfig
+	}
+
+	DestroyActions interface {
+		Destroy(cCtx *cli.Context) error
+	}
+
+	DestroyContext interface {
+		CommandBuilder
+		DestroyActions
+	}
+)
+
+func NewDestroyContext(session *Session) DestroyContext {
+	return &DefaultDestroyActions{
+		Session: session,
+	}
+}
+
+func (a *DefaultDestroyActions) load(ctx context.Context) error {
+	if a.ApplicationConfig != nil {
+		return nil
+	}
+	applicationConfig, err := a.Session.LoadEnvironmentConfig(ctx)
+	if err != nil {
+		return err
+	}
+	s3Adapter, err := adapter.NewS3Adapter(ctx)
+	if err != nil {
+		return err
+	}
+	cloudformationAdapter, err := adapter.NewCloudFormationAdapter(ctx, a.Session.StdOut, a.Session.PollInterval)
+	if err != nil {
+		return err
+	}
+	a.S3Adapter = s3Adapter
+	a.CloudFormationAdapter = cloudformationAdapter
+	a.ApplicationConfig = applicationConfig
+	return nil
+}
+
+func (a *DefaultDestroyActions) Destroy(cCtx *cli.Context) error {
+	ctx, cancel := a.Session.Context(cCtx)
+	defer cancel()
+	if err := a.load(ctx); err != nil {
+		return err
+	}
+	stackName := *a.ApplicationConfig.Name
+	status, err := a.CloudFormationAdapter.GetDeploymentStatus(ctx, stackName)
+	if err != nil {
+		return fmt.Errorf("error getting deployment status: %w", err)
+	}
+	if status.TerminationProtection && !cCtx.Bool("force") {
+		return fmt.Errorf("stack %s has termination protection enabled, pass --force to destroy it anyway", stackName)
+	}
+	if !cCtx.Bool("yes") {
+		confirmed, err := a.Session.Confirm(fmt.Sprintf("Destroy application %s and all of its artifacts?", stackName))
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			a.Session.StdOut.Info("Destroy aborted", "application", stackName)
+			return nil
+		}
+	}
+
+	if status.Found {
+		if status.TerminationProtection {
+			a.Session.StdOut.Warn("Disabling termination protection", "stackName", stackName)
+			err = a.CloudFormationAdapter.DisableTerminationProtection(ctx, stackName)
+			if err != nil {
+				return fmt.Errorf("error disabling termination protection: %w", err)
+			}
+		}
+		a.Session.StdOut.Info("Deleting stack", "stackName", stackName)
+		err = a.CloudFormationAdapter.DeleteStack(ctx, stackName)
+		if err != nil {
+			return fmt.Errorf("error deleting stack: %w", err)
+		}
+	} else {
+		a.Session.StdOut.Info("Stack not found, skipping", "stackName", stackName)
+	}
+
+	bootstrap, err := a.Session.LoadBootstrapConfig(a.ApplicationConfig)
+	if err != nil {
+		return fmt.Errorf("error loading bootstrap config, artifacts were not removed: %w", err)
+	}
+	prefix := stackName + "/"
+	a.Session.StdOut.Info("Removing artifacts", "bucket", *bootstrap.S3BucketName, "prefix", prefix)
+	deleted, err := a.S3Adapter.DeleteFiles(ctx, *bootstrap.S3BucketName, prefix)
+	if err != nil {
+		return fmt.Errorf("error removing artifacts: %w", err)
+	}
+	a.Session.StdOut.Info("Application destroyed", "application", stackName, "artifacts", deleted)
+	return nil
+}
+
+func (a *DefaultDestroyActions) CreateCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "destroy",
+		Usage:  "Deletes the application stack and its artifacts from AWS",
+		Action: a.Destroy,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "destroy the stack even if termination protection is enabled",
+			},
+			&cli.BoolFlag{
+				Name:  "yes",
+				Usage: "destroy without asking for confirmation",
+			},
+		},
+	}
+}
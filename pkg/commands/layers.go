@@ -0,0 +1,122 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/charmbracelet/log"
+	"github.com/stefan79/gadget-cli/pkg/adapter"
+	"github.com/stefan79/gadget-cli/pkg/config"
+)
+
+// maxFunctionLayers is the number of layers Lambda allows per function.
+const maxFunctionLayers = 5
+
+// validateLayers checks the layer references of the commands before anything is built.
+func (a *DefaultDeployActions) validateLayers() error {
+	declared := make(map[string]bool)
+	for _, layer := range a.ApplicationConfig.Layers {
+		if layer.Name == nil || layer.Path == nil {
+			return fmt.Errorf("every layer needs a name and a path")
+		}
+		if declared[*layer.Name] {
+			return fmt.Errorf("layer %s is declared twice", *layer.Name)
+		}
+		declared[*layer.Name] = true
+	}
+	for _, command := range a.ApplicationConfig.Commands {
+		packageType, err := command.PackageTypeOf()
+		if err != nil {
+			return err
+		}
+		if packageType == config.PackageTypeImage && len(command.Layers) > 0 {
+			return fmt.Errorf("command %s is packaged as image, images cannot use layers", *command.Name)
+		}
+		if len(command.Layers) > maxFunctionLayers {
+			return fmt.Errorf("command %s uses %d layers, Lambda allows %d", *command.Name, len(command.Layers), maxFunctionLayers)
+		}
+		for _, name := range command.Layers {
+			if _, err := a.ApplicationConfig.LayerByName(name); err != nil {
+				return fmt.Errorf("command %s: %w", *command.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// prepareLayers packages and uploads every declared layer and adds it to the application template.
+func (a *DefaultDeployActions) prepareLayers(ctx context.Context, options buildOptions) ([]*adapter.LayerArtifact, error) {
+	artifacts := make([]*adapter.LayerArtifact, 0, len(a.ApplicationConfig.Layers))
+	for _, layer := range a.ApplicationConfig.Layers {
+		logger := a.Session.StdOut.With("layer", *layer.Name)
+		logger.Info("Preparing layer")
+		artifact, err := a.prepareLayerDeployment(ctx, layer, options, logger)
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", *layer.Name, err)
+		}
+		err = a.GadgetoFormationAdapter.AddLayerVersion(&adapter.LayerTemplateOptions{
+			Name:          *layer.Name,
+			Bucket:        artifact.Bucket,
+			Key:           artifact.Key,
+			Architectures: layer.Architectures,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error adding layer %s to the template: %w", *layer.Name, err)
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	return artifacts, nil
+}
+
+func (a *DefaultDeployActions) prepareLayerDeployment(ctx context.Context, layer *config.Layer, options buildOptions, logger *log.Logger) (*adapter.LayerArtifact, error) {
+	assets, err := a.StagingAdapter.ResolveAssets(*layer.Path, layer.IncludeOf())
+	if err != nil {
+		return nil, err
+	}
+	zipFileName := "layer_" + *layer.Name + ".zip"
+	logger.Debug("Zipping layer", "zipfile", zipFileName, "files", len(assets))
+	err = a.StagingAdapter.ZipLayer(assets, &zipFileName)
+	if err != nil {
+		return nil, err
+	}
+	fullZipFileName, err := a.StagingAdapter.GetFileFromStaging(&zipFileName)
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := a.StagingAdapter.CalculateCheckSum(&zipFileName)
+	if err != nil {
+		return nil, fmt.Errorf("error calculating checksum: %w", err)
+	}
+	bucketKey := layerArtifactKey(*a.ApplicationConfig.Name, *layer.Name, checksum)
+	artifact := &adapter.LayerArtifact{
+		Layer:    *layer.Name,
+		Archive:  *fullZipFileName,
+		Checksum: checksum,
+		Bucket:   options.bucketName,
+		Key:      bucketKey,
+	}
+	if options.dryRun {
+		logger.Debug("Skipping upload in dry run", "bucket", options.bucketName, "key", bucketKey)
+		return artifact, nil
+	}
+	existing, err := a.S3Adapter.HeadFile(ctx, options.bucketName, bucketKey)
+	if err != nil {
+		return nil, fmt.Errorf("error checking for artifact %s in bucket %s: %w", bucketKey, options.bucketName, err)
+	}
+	if existing != nil {
+		logger.Debug("Layer unchanged, skipping upload", "bucket", options.bucketName, "key", bucketKey)
+		return artifact, nil
+	}
+	logger.Debug("Uploading layer", "bucket", options.bucketName, "key", bucketKey)
+	err = a.S3Adapter.UploadFile(ctx, *fullZipFileName, options.bucketName, bucketKey)
+	if err != nil {
+		return nil, fmt.Errorf("error uploading file %s to bucket %s: %w", *fullZipFileName, options.bucketName, err)
+	}
+	artifact.Uploaded = true
+	return artifact, nil
+}
+
+// layerArtifactKey keeps layers apart from the command artifacts of the application.
+func layerArtifactKey(appName string, layerName string, checksum string) string {
+	return fmt.Sprintf("%s/layers/%s/%s.zip", appName, layerName, checksum)
+}
@@ -1,8 +1,14 @@
 package commands
 
 import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/charmbracelet/log"
 
@@ -17,6 +23,16 @@ type (
 		StagingPath           *string
 		StdOut                *log.Logger
 		StdErr                *log.Logger
+		StdIn                 io.Reader
+		Prompt                io.Writer
+		PollInterval          *time.Duration
+		Timeout               *time.Duration
+		NoCache               *bool
+		GeneratorTimeout      *time.Duration
+		RegistryUsername      *string
+		RegistryPassword      *string
+		RegistryInsecure      *bool
+		Environment           *string
 	}
 
 	CommandBuilder interface {
@@ -24,6 +40,9 @@ type (
 	}
 )
 
+// minPollInterval keeps progress checks from running into the CloudFormation API rate limits.
+const minPollInterval = time.Second
+
 func NewSession() *Session {
 
 	defaultPath := "./gadget.yaml"
@@ -32,35 +51,172 @@ func NewSession() *Session {
 	stdErr := log.New(os.Stderr)
 	stdOut := log.New(os.Stdout)
 	stdOut.SetLevel(log.DebugLevel)
+	defaultPollInterval := 5 * time.Second
+	defaultTimeout := time.Duration(0)
+	defaultNoCache := false
+	defaultGeneratorTimeout := 2 * time.Minute
+	defaultRegistryUsername := ""
+	defaultRegistryPassword := ""
+	defaultRegistryInsecure := false
+	defaultEnvironment := ""
 	return &Session{
 		ApplicationConfigPath: &defaultPath,
 		WorkPath:              &defaultWorkPath,
 		StagingPath:           &defaultStagingPath,
 		StdOut:                stdOut,
 		StdErr:                stdErr,
+		StdIn:                 os.Stdin,
+		Prompt:                os.Stderr,
+		PollInterval:          &defaultPollInterval,
+		Timeout:               &defaultTimeout,
+		NoCache:               &defaultNoCache,
+		GeneratorTimeout:      &defaultGeneratorTimeout,
+		RegistryUsername:      &defaultRegistryUsername,
+		RegistryPassword:      &defaultRegistryPassword,
+		RegistryInsecure:      &defaultRegistryInsecure,
+		Environment:           &defaultEnvironment,
+	}
+}
+
+func (s *Session) CreateFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.DurationFlag{
+			Name:        "poll-interval",
+			Usage:       "interval between CloudFormation progress checks, at least 1s",
+			Value:       *s.PollInterval,
+			Destination: s.PollInterval,
+			Action: func(_ *cli.Context, interval time.Duration) error {
+				if interval < minPollInterval {
+					return fmt.Errorf("--poll-interval has to be at least %s, got %s", minPollInterval, interval)
+				}
+				return nil
+			},
+		},
+		&cli.DurationFlag{
+			Name:        "timeout",
+			Usage:       "abort the command after the given duration, 0 disables the timeout",
+			Value:       *s.Timeout,
+			Destination: s.Timeout,
+		},
+		&cli.DurationFlag{
+			Name:        "generator-timeout",
+			Usage:       "abort a command template generator after the given duration, 0 disables the timeout",
+			Value:       *s.GeneratorTimeout,
+			Destination: s.GeneratorTimeout,
+		},
+		&cli.StringFlag{
+			Name:        "registry-username",
+			Usage:       "username for the image registry",
+			EnvVars:     []string{"GADGET_REGISTRY_USERNAME"},
+			Destination: s.RegistryUsername,
+		},
+		&cli.StringFlag{
+			Name:        "registry-password",
+			Usage:       "password or token for the image registry",
+			EnvVars:     []string{"GADGET_REGISTRY_PASSWORD"},
+			Destination: s.RegistryPassword,
+		},
+		&cli.BoolFlag{
+			Name:        "registry-insecure",
+			Usage:       "talk plain http to the image registry",
+			Destination: s.RegistryInsecure,
+		},
+		&cli.StringFlag{
+			Name:        "env",
+			Usage:       "environment of gadget.yaml to work with, e.g. dev or prod",
+			EnvVars:     []string{"GADGET_ENV"},
+			Destination: s.Environment,
+		},
+	}
+}
+
+// Context derives the context of a command from the signal aware context of the cli and the configured timeout.
+func (s *Session) Context(cCtx *cli.Context) (context.Context, context.CancelFunc) {
+	if *s.Timeout > 0 {
+		return context.WithTimeout(cCtx.Context, *s.Timeout)
 	}
+	return context.WithCancel(cCtx.Context)
 }
 
-func (s *Session) LoadApplicationConfig() (*config.ApplicationConfig, error) {
+// Confirm asks a yes or no question, the question goes to stderr to keep it out of piped output.
+func (s *Session) Confirm(question string) (bool, error) {
+	fmt.Fprintf(s.Prompt, "%s [y/N]: ", question)
+	answer, err := bufio.NewReader(s.StdIn).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// LoadApplicationConfig loads gadget.yaml with its variables resolved.
+func (s *Session) LoadApplicationConfig(ctx context.Context) (*config.ApplicationConfig, error) {
 	conf, err := config.LoadConfig(*s.ApplicationConfigPath)
 	if err != nil {
 		return nil, err
 	}
+	err = config.Interpolate(ctx, conf, filepath.Dir(*s.ApplicationConfigPath))
+	if err != nil {
+		return nil, fmt.Errorf("error resolving variables of %s:\n%w", *s.ApplicationConfigPath, err)
+	}
 	return conf, nil
 }
 
+// LoadRawApplicationConfig loads gadget.yaml as written, commands changing the file keep its variables.
+func (s *Session) LoadRawApplicationConfig() (*config.ApplicationConfig, error) {
+	return config.LoadConfig(*s.ApplicationConfigPath)
+}
+
 func (s *Session) SaveApplicationConfig(conf *config.ApplicationConfig) error {
 	return config.SaveConfig(conf, *s.ApplicationConfigPath)
 }
 
-func (s *Session) LoadBootstrapConfig() (*config.Bootstrap, error) {
-	bootstrap, err := config.LoadBootstrap(filepath.Join(*s.WorkPath, "bootstrap.yaml"))
+// LoadEnvironmentConfig loads the application as it is deployed to the environment selected with --env.
+func (s *Session) LoadEnvironmentConfig(ctx context.Context) (*config.ApplicationConfig, error) {
+	conf, err := s.LoadApplicationConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return conf.ForEnvironment(*s.Environment)
+}
+
+// ArtifactManifestName keeps the artifacts of every environment apart, push and status read those of the selected one.
+func (s *Session) ArtifactManifestName() string {
+	if *s.Environment == "" {
+		return "artifacts.yaml"
+	}
+	return fmt.Sprintf("artifacts-%s.yaml", *s.Environment)
+}
+
+// BootstrapConfigPath is the bootstrap config of the selected environment, environments without one share the default.
+// Like files referenced by variables, the bootstrap config of an environment is relative to gadget.yaml.
+func (s *Session) BootstrapConfigPath(conf *config.ApplicationConfig) string {
+	if conf != nil {
+		if bootstrapPath := conf.BootstrapOf(*s.Environment); bootstrapPath != nil {
+			if filepath.IsAbs(*bootstrapPath) {
+				return *bootstrapPath
+			}
+			return filepath.Join(filepath.Dir(*s.ApplicationConfigPath), *bootstrapPath)
+		}
+	}
+	return filepath.Join(*s.WorkPath, "bootstrap.yaml")
+}
+
+func (s *Session) LoadBootstrapConfig(conf *config.ApplicationConfig) (*config.Bootstrap, error) {
+	bootstrap, err := config.LoadBootstrap(s.BootstrapConfigPath(conf))
 	if err != nil {
 		return nil, err
 	}
 	return bootstrap, nil
 }
 
-func (s *Session) SaveBootstrapConfig(bootstrap *config.Bootstrap) error {
-	return config.SaveBootstrap(filepath.Join(*s.WorkPath, "bootstrap.yaml"), bootstrap)
+func (s *Session) SaveBootstrapConfig(conf *config.ApplicationConfig, bootstrap *config.Bootstrap) error {
+	bootstrapPath := s.BootstrapConfigPath(conf)
+	if err := os.MkdirAll(filepath.Dir(bootstrapPath), 0755); err != nil {
+		return err
+	}
+	return config.SaveBootstrap(bootstrapPath, bootstrap)
 }
@@ -0,0 +1,39 @@
+package commands
+
+import (
+	"github.com/charmbracelet/log"
+	"github.com/stefan79/gadget-cli/pkg/adapter"
+)
+
+func renderChangeSetPlan(logger *log.Logger, plan *adapter.ChangeSetPlan) {
+	logger.Info("Change set plan", "stack", plan.StackName, "changeSet", plan.ChangeSetName, "changes", len(plan.Changes))
+	replacements := 0
+	iamChanges := 0
+	for _, change := range plan.Changes {
+		keyvals := []interface{}{
+			"action", change.Action,
+			"resource", change.LogicalId,
+			"type", change.ResourceType,
+		}
+		if change.Replacement != "" {
+			keyvals = append(keyvals, "replacement", change.Replacement)
+		}
+		switch {
+		case change.RequiresReplacement():
+			replacements++
+			logger.Warn("Resource will be replaced", keyvals...)
+		case change.IsIAM():
+			logger.Warn("IAM resource will change", keyvals...)
+		case change.MayRequireReplacement():
+			logger.Warn("Resource may be replaced", keyvals...)
+		default:
+			logger.Info("Resource will change", keyvals...)
+		}
+		if change.IsIAM() {
+			iamChanges++
+		}
+	}
+	if replacements > 0 || iamChanges > 0 {
+		logger.Warn("Change set contains sensitive changes", "replacements", replacements, "iam", iamChanges)
+	}
+}
@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/stefan79/gadget-cli/pkg/adapter"
+	"github.com/stefan79/gadget-cli/pkg/config"
+	"github.com/urfave/cli/v2"
+)
+
+type (
+	DefaultPushActions struct {
+		Session           *Session
+		StagingAdapter    adapter.StagingAdapter
+		RegistryAdapter   adapter.RegistryAdapter
+		ApplicationConfig *config.ApplicationConfig
+	}
+
+	PushActions interface {
+		Push(cCtx *cli.Context) error
+	}
+
+	PushContext interface {
+		CommandBuilder
+		PushActions
+	}
+)
+
+func NewPushContext(session *Session) PushContext {
+	return &DefaultPushActions{
+		Session: session,
+	}
+}
+
+func (a *DefaultPushActions) load(ctx context.Context) error {
+	if a.ApplicationConfig != nil {
+		return nil
+	}
+	session := a.Session
+	applicationConfig, err := session.LoadEnvironmentConfig(ctx)
+	if err != nil {
+		return err
+	}
+	stagingAdapter, err := adapter.NewStagingAdapter(applicationConfig.Name, session.StagingPath, session.WorkPath, session.NoCache, session.GeneratorTimeout, session.StdOut)
+	if err != nil {
+		return err
+	}
+	registryAdapter, err := adapter.NewRegistryAdapter(session.RegistryUsername, session.RegistryPassword, session.RegistryInsecure, session.StdOut)
+	if err != nil {
+		return err
+	}
+	a.StagingAdapter = stagingAdapter
+	a.RegistryAdapter = registryAdapter
+	a.ApplicationConfig = applicationConfig
+	return nil
+}
+
+// Push uploads the images of the last build, so synth, push and deploy can run as separate steps.
+func (a *DefaultPushActions) Push(cCtx *cli.Context) error {
+	ctx, cancel := a.Session.Context(cCtx)
+	defer cancel()
+	if err := a.load(ctx); err != nil {
+		return err
+	}
+	manifestName := a.Session.ArtifactManifestName()
+	manifest, err := a.StagingAdapter.LoadArtifactManifest(&manifestName)
+	if err != nil {
+		return fmt.Errorf("error loading artifact manifest, did you run gadget synth?: %w", err)
+	}
+	errs := make([]error, 0)
+	pushed := 0
+	for _, artifact := range manifest.Artifacts {
+		if artifact.PackageType != config.PackageTypeImage {
+			continue
+		}
+		repository, _, _ := strings.Cut(artifact.ImageUri, "@")
+		if repository == synthImageRepository {
+			errs = append(errs, fmt.Errorf("command %s was synthesized without an imageRepository", artifact.Command))
+			continue
+		}
+		a.Session.StdOut.Info("Pushing image", "command", artifact.Command, "repository", repository, "tag", artifact.ImageTag)
+		uploaded, err := a.RegistryAdapter.PushImage(ctx, artifact.Image, repository, artifact.ImageTag)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("command %s: %w", artifact.Command, err))
+			continue
+		}
+		a.Session.StdOut.Info("Image", "command", artifact.Command, "image", artifact.ImageUri, "pushed", uploaded)
+		pushed++
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	if pushed == 0 {
+		a.Session.StdOut.Info("No command is packaged as image")
+	}
+	return nil
+}
+
+func (a *DefaultPushActions) CreateCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "push",
+		Usage:  "Pushes the images of commands packaged as image to the image repository",
+		Action: a.Push,
+	}
+}
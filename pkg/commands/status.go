@@ -0,0 +1,249 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/stefan79/gadget-cli/pkg/adapter"
+	"github.com/stefan79/gadget-cli/pkg/config"
+	"github.com/urfave/cli/v2"
+)
+
+type (
+	DefaultStatusActions struct {
+		Session               *Session
+		CloudFormationAdapter adapter.CloudFormationAdapter
+		StagingAdapter        adapter.StagingAdapter
+		ApplicationConfig     *config.ApplicationConfig
+	}
+
+	StatusActions interface {
+		Status(cCtx *cli.Context) error
+	}
+
+	StatusContext interface {
+		CommandBuilder
+		StatusActions
+	}
+
+	ApplicationStatus struct {
+		Application string            `json:"application"`
+		Status      string            `json:"status"`
+		Phase       string            `json:"phase"`
+		LastUpdated *time.Time        `json:"lastUpdated,omitempty"`
+		Outputs     map[string]string `json:"outputs"`
+		Commands    []*CommandStatus  `json:"commands"`
+	}
+
+	CommandStatus struct {
+		Name              string `json:"name"`
+		FunctionName      string `json:"functionName,omitempty"`
+		DeployedChecksum  string `json:"deployedChecksum,omitempty"`
+		LastBuildChecksum string `json:"lastBuildChecksum,omitempty"`
+		LastBuildDiffers  *bool  `json:"lastBuildDiffers,omitempty"`
+	}
+)
+
+func NewStatusContext(session *Session) StatusContext {
+	return &DefaultStatusActions{
+		Session: session,
+	}
+}
+
+func (a *DefaultStatusActions) load(ctx context.Context) error {
+	if a.ApplicationConfig != nil {
+		return nil
+	}
+	session := a.Session
+	applicationConfig, err := session.LoadEnvironmentConfig(ctx)
+	if err != nil {
+		return err
+	}
+	stagingAdapter, err := adapter.NewStagingAdapter(applicationConfig.Name, session.StagingPath, session.WorkPath, session.NoCache, session.GeneratorTimeout, session.StdOut)
+	if err != nil {
+		return err
+	}
+	cloudformationAdapter, err := adapter.NewCloudFormationAdapter(ctx, session.StdOut, session.PollInterval)
+	if err != nil {
+		return err
+	}
+	a.CloudFormationAdapter = cloudformationAdapter
+	a.StagingAdapter = stagingAdapter
+	a.ApplicationConfig = applicationConfig
+	return nil
+}
+
+func (a *DefaultStatusActions) Status(cCtx *cli.Context) error {
+	ctx, cancel := a.Session.Context(cCtx)
+	defer cancel()
+	if err := a.load(ctx); err != nil {
+		return err
+	}
+	status, err := a.collectStatus(ctx)
+	if err != nil {
+		return err
+	}
+	switch cCtx.String("output") {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(status)
+	case "text":
+		return renderStatus(os.Stdout, status)
+	default:
+		return fmt.Errorf("unknown output format %s, expected text or json", cCtx.String("output"))
+	}
+}
+
+func (a *DefaultStatusActions) collectStatus(ctx context.Context) (*ApplicationStatus, error) {
+	stackName := *a.ApplicationConfig.Name
+	deployment, err := a.CloudFormationAdapter.GetDeploymentStatus(ctx, stackName)
+	if err != nil {
+		return nil, fmt.Errorf("error getting deployment status: %w", err)
+	}
+	status := &ApplicationStatus{
+		Application: stackName,
+		Status:      deployment.Status,
+		Phase:       string(deployment.Phase),
+		LastUpdated: deployment.LastUpdated,
+		Outputs:     deployment.Outputs,
+		Commands:    make([]*CommandStatus, 0, len(a.ApplicationConfig.Commands)),
+	}
+	if status.Outputs == nil {
+		status.Outputs = make(map[string]string)
+	}
+
+	byCommand := make(map[string]*CommandStatus)
+	for _, command := range a.ApplicationConfig.Commands {
+		commandStatus := &CommandStatus{Name: *command.Name}
+		byCommand[*command.Name] = commandStatus
+		status.Commands = append(status.Commands, commandStatus)
+	}
+
+	if deployment.Found {
+		if err := a.collectDeployedFunctions(ctx, stackName, byCommand); err != nil {
+			return nil, err
+		}
+	}
+
+	manifestName := a.Session.ArtifactManifestName()
+	manifest, err := a.StagingAdapter.LoadArtifactManifest(&manifestName)
+	if err != nil {
+		a.Session.StdErr.Debug("No artifact manifest of a previous build found", "err", err)
+	} else {
+		for _, artifact := range manifest.Artifacts {
+			if commandStatus, found := byCommand[artifact.Command]; found {
+				commandStatus.LastBuildChecksum = artifact.Checksum
+			}
+		}
+	}
+	for _, commandStatus := range status.Commands {
+		if commandStatus.LastBuildChecksum != "" && commandStatus.DeployedChecksum != "" {
+			differs := commandStatus.LastBuildChecksum != commandStatus.DeployedChecksum
+			commandStatus.LastBuildDiffers = &differs
+		}
+	}
+	return status, nil
+}
+
+func (a *DefaultStatusActions) collectDeployedFunctions(ctx context.Context, stackName string, byCommand map[string]*CommandStatus) error {
+	templateAsBytes, err := a.CloudFormationAdapter.LoadTemplate(ctx, stackName)
+	if err != nil {
+		return fmt.Errorf("error loading deployed template: %w", err)
+	}
+	functions, err := adapter.ReadDeployedFunctions(templateAsBytes)
+	if err != nil {
+		return fmt.Errorf("error reading deployed template: %w", err)
+	}
+	resources, err := a.CloudFormationAdapter.ListStackResources(ctx, stackName)
+	if err != nil {
+		return fmt.Errorf("error listing stack resources: %w", err)
+	}
+	physicalIds := make(map[string]string, len(resources))
+	for _, resource := range resources {
+		physicalIds[resource.LogicalId] = resource.PhysicalId
+	}
+	for _, function := range functions {
+		commandStatus, found := byCommand[function.Command]
+		if !found {
+			continue
+		}
+		commandStatus.FunctionName = physicalIds[function.LogicalId]
+		if function.S3Key != "" {
+			commandStatus.DeployedChecksum = checksumFromKey(function.S3Key)
+		} else if function.ImageUri != "" {
+			commandStatus.DeployedChecksum = checksumFromImageUri(function.ImageUri)
+		}
+	}
+	return nil
+}
+
+func renderStatus(w io.Writer, status *ApplicationStatus) error {
+	fmt.Fprintf(w, "Application:  %s\n", status.Application)
+	fmt.Fprintf(w, "Status:       %s\n", status.Status)
+	if status.LastUpdated != nil {
+		fmt.Fprintf(w, "Last updated: %s\n", status.LastUpdated.Local().Format(time.RFC1123))
+	}
+	if len(status.Outputs) > 0 {
+		fmt.Fprintln(w, "\nOutputs:")
+		keys := make([]string, 0, len(status.Outputs))
+		for key := range status.Outputs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, "  %s = %s\n", key, status.Outputs[key])
+		}
+	}
+	fmt.Fprintln(w, "\nCommands:")
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "  NAME\tFUNCTION\tDEPLOYED CHECKSUM\tLAST BUILD")
+	for _, command := range status.Commands {
+		lastBuild := "unknown"
+		if command.LastBuildDiffers != nil {
+			lastBuild = "deployed"
+			if *command.LastBuildDiffers {
+				lastBuild = "not deployed"
+			}
+		}
+		fmt.Fprintf(table, "  %s\t%s\t%s\t%s\n", command.Name, orDash(command.FunctionName), orDash(shortChecksum(command.DeployedChecksum)), lastBuild)
+	}
+	return table.Flush()
+}
+
+func shortChecksum(checksum string) string {
+	if len(checksum) > 12 {
+		return checksum[:12]
+	}
+	return checksum
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func (a *DefaultStatusActions) CreateCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "status",
+		Usage: "Shows the deployment status of the application and its commands",
+		Description: "LAST BUILD tells whether the artifacts of the last synth or deploy are deployed,\n" +
+			"it does not look at sources changed since.",
+		Action: a.Status,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "output format, text or json",
+				Value: "text",
+			},
+		},
+	}
+}
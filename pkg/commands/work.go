@@ -1,11 +1,15 @@
 package commands
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/stefan79/gadget-cli/pkg/config"
 	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
 )
 
 type (
@@ -17,6 +21,9 @@ type (
 		Init(cCtx *cli.Context) error
 		Use(cCtx *cli.Context) error
 		SetTag(cCtx *cli.Context) error
+		Validate(cCtx *cli.Context) error
+		Schema(cCtx *cli.Context) error
+		Render(cCtx *cli.Context) error
 	}
 
 	WorkContext interface {
@@ -34,7 +41,7 @@ func NewWorkContext(session *Session) WorkContext {
 func (a *DefaultWorkActions) SetTag(cCtx *cli.Context) error {
 	key := cCtx.String("key")
 	value := cCtx.String("value")
-	conf, err := a.Session.LoadApplicationConfig()
+	conf, err := a.Session.LoadRawApplicationConfig()
 	if err != nil {
 		return err
 	}
@@ -55,7 +62,7 @@ func (a *DefaultWorkActions) Init(cCtx *cli.Context) error {
 }
 
 func (a *DefaultWorkActions) Use(cCtx *cli.Context) error {
-	conf, err := a.Session.LoadApplicationConfig()
+	conf, err := a.Session.LoadRawApplicationConfig()
 	if err != nil {
 		return err
 	}
@@ -74,6 +81,50 @@ func (a *DefaultWorkActions) Use(cCtx *cli.Context) error {
 	return a.Session.SaveApplicationConfig(conf)
 }
 
+// Validate reports every problem of gadget.yaml with its position instead of stopping at the first one.
+func (a *DefaultWorkActions) Validate(cCtx *cli.Context) error {
+	filePath := *a.Session.ApplicationConfigPath
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	problems := config.Validate(data)
+	for _, problem := range problems {
+		fmt.Fprintf(cCtx.App.ErrWriter, "%s:%s\n", filePath, problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems found in %s", len(problems), filePath)
+	}
+	a.Session.StdOut.Info("Configuration is valid", "file", filePath)
+	return nil
+}
+
+// Schema prints the JSON Schema of gadget.yaml, editors use it for completion and inline validation.
+func (a *DefaultWorkActions) Schema(cCtx *cli.Context) error {
+	schema, err := json.MarshalIndent(config.ApplicationSchema(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(cCtx.App.Writer, string(schema))
+	return err
+}
+
+// Render prints the config with its variables resolved, as it is deployed to the environment selected with --env.
+func (a *DefaultWorkActions) Render(cCtx *cli.Context) error {
+	ctx, cancel := a.Session.Context(cCtx)
+	defer cancel()
+	conf, err := a.Session.LoadEnvironmentConfig(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(conf)
+	if err != nil {
+		return err
+	}
+	_, err = cCtx.App.Writer.Write(data)
+	return err
+}
+
 func (a *DefaultWorkActions) CreateCommand() *cli.Command {
 	cmd := &cli.Command{
 		Name:  "work",
@@ -109,6 +160,21 @@ func (a *DefaultWorkActions) CreateCommand() *cli.Command {
 					},
 				},
 			},
+			{
+				Name:   "validate",
+				Usage:  "validate gadget.yaml and report every problem",
+				Action: a.Validate,
+			},
+			{
+				Name:   "schema",
+				Usage:  "print the JSON Schema of gadget.yaml",
+				Action: a.Schema,
+			},
+			{
+				Name:   "render",
+				Usage:  "print gadget.yaml with its variables resolved",
+				Action: a.Render,
+			},
 		},
 	}
 	return cmd
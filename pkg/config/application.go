@@ -3,23 +3,240 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 
 	"gopkg.in/yaml.v2"
 )
 
 type (
 	ApplicationConfig struct {
-		Name     *string
-		Commands []*Command
-		Tags     map[string]string
+		Name            *string `jsonschema:"required"`
+		Commands        []*Command
+		Tags            map[string]string
+		Architecture    *string                 `yaml:"architecture,omitempty"`
+		ImageRepository *string                 `yaml:"imageRepository,omitempty"`
+		Layers          []*Layer                `yaml:"layers,omitempty"`
+		Parameters      map[string]string       `yaml:"parameters,omitempty"`
+		Environments    map[string]*Environment `yaml:"environments,omitempty"`
+	}
+
+	// Environment overrides the application for one stage like dev or prod, it is selected with --env.
+	Environment struct {
+		Tags          map[string]string `yaml:"tags,omitempty"`
+		Parameters    map[string]string `yaml:"parameters,omitempty"`
+		Bootstrap     *string           `yaml:"bootstrap,omitempty"`
+		BuildSettings `yaml:",inline"`
+	}
+
+	// Layer is packaged once and shared by every command listing it.
+	Layer struct {
+		Name          *string  `jsonschema:"required"`
+		Path          *string  `jsonschema:"required"`
+		Include       []string `yaml:"include,omitempty"`
+		Architectures []string `yaml:"architectures,omitempty"`
 	}
 
 	Command struct {
-		Name *string
-		Path *string
+		Name          *string  `jsonschema:"required"`
+		Path          *string  `jsonschema:"required"`
+		Architecture  *string  `yaml:"architecture,omitempty"`
+		Assets        []string `yaml:"assets,omitempty"`
+		PackageType   *string  `yaml:"packageType,omitempty"`
+		BaseImage     *string  `yaml:"baseImage,omitempty"`
+		Layers        []string `yaml:"layers,omitempty"`
+		MaxSize       *string  `yaml:"maxSize,omitempty"`
+		BuildSettings `yaml:",inline"`
 	}
+
+	BuildSettings struct {
+		BuildTags       []string          `yaml:"buildTags,omitempty"`
+		Ldflags         []string          `yaml:"ldflags,omitempty"`
+		Gcflags         []string          `yaml:"gcflags,omitempty"`
+		Cgo             *bool             `yaml:"cgo,omitempty"`
+		Env             map[string]string `yaml:"env,omitempty"`
+		VersionVariable *string           `yaml:"versionVariable,omitempty"`
+		Strip           *bool             `yaml:"strip,omitempty"`
+	}
+)
+
+const (
+	ArchitectureX86_64 = "x86_64"
+	ArchitectureArm64  = "arm64"
+)
+
+var Architectures = []string{ArchitectureX86_64, ArchitectureArm64}
+
+const (
+	PackageTypeZip   = "zip"
+	PackageTypeImage = "image"
 )
 
+var PackageTypes = []string{PackageTypeZip, PackageTypeImage}
+
+// ArchitectureOf resolves the Lambda architecture of a command, falling back to the application and then x86_64.
+func (ac *ApplicationConfig) ArchitectureOf(cmd *Command) (string, error) {
+	architecture := ArchitectureX86_64
+	if cmd.Architecture != nil {
+		architecture = *cmd.Architecture
+	} else if ac.Architecture != nil {
+		architecture = *ac.Architecture
+	}
+	for _, supported := range Architectures {
+		if architecture == supported {
+			return architecture, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported architecture %s for command %s, expected one of %v", architecture, *cmd.Name, Architectures)
+}
+
+// PackageTypeOf resolves how a command is packaged, commands are zipped unless configured otherwise.
+func (cmd *Command) PackageTypeOf() (string, error) {
+	if cmd.PackageType == nil {
+		return PackageTypeZip, nil
+	}
+	for _, supported := range PackageTypes {
+		if *cmd.PackageType == supported {
+			return supported, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported package type %s for command %s, expected one of %v", *cmd.PackageType, *cmd.Name, PackageTypes)
+}
+
+// LayerByName resolves a layer referenced by a command.
+func (ac *ApplicationConfig) LayerByName(name string) (*Layer, error) {
+	for _, layer := range ac.Layers {
+		if layer.Name != nil && *layer.Name == name {
+			return layer, nil
+		}
+	}
+	return nil, fmt.Errorf("layer %s is not declared in the application", name)
+}
+
+// IncludeOf lists the patterns packaged into a layer, relative to its path, the whole directory by default.
+func (l *Layer) IncludeOf() []string {
+	if len(l.Include) == 0 {
+		return []string{"*"}
+	}
+	return l.Include
+}
+
+// StripOf reports whether symbol tables and DWARF data are stripped from the binary, which is the default.
+func (b *BuildSettings) StripOf() bool {
+	return b.Strip == nil || *b.Strip
+}
+
+// MaxSizeOf resolves the size budget of the cross compiled binary of a command, 0 means unlimited.
+func (cmd *Command) MaxSizeOf() (int64, error) {
+	if cmd.MaxSize == nil {
+		return 0, nil
+	}
+	size, err := ParseSize(*cmd.MaxSize)
+	if err != nil {
+		return 0, fmt.Errorf("invalid maxSize of command %s: %w", *cmd.Name, err)
+	}
+	return size, nil
+}
+
+// Dir is the directory of the command package, asset patterns are relative to it.
+func (cmd *Command) Dir() string {
+	if filepath.Ext(*cmd.Path) == ".go" {
+		return filepath.Dir(*cmd.Path)
+	}
+	return *cmd.Path
+}
+
+// HasExplicitArchitecture reports whether the architecture of a command was configured rather than defaulted.
+func (ac *ApplicationConfig) HasExplicitArchitecture(cmd *Command) bool {
+	return cmd.Architecture != nil || ac.Architecture != nil
+}
+
+// ForEnvironment resolves the config deployed to an environment, an empty name selects the application as is.
+// The environment becomes part of the name, so every environment has its own stack and artifact prefix.
+func (ac *ApplicationConfig) ForEnvironment(name string) (*ApplicationConfig, error) {
+	if name == "" {
+		return ac, nil
+	}
+	environment, found := ac.Environments[name]
+	if !found {
+		return nil, fmt.Errorf("environment %s is not declared in the application, expected one of %v", name, ac.EnvironmentNames())
+	}
+	if environment == nil {
+		environment = &Environment{}
+	}
+	resolved := *ac
+	resolvedName := fmt.Sprintf("%s-%s", *ac.Name, name)
+	resolved.Name = &resolvedName
+	resolved.Tags = mergeStrings(ac.Tags, environment.Tags)
+	resolved.Parameters = mergeStrings(ac.Parameters, environment.Parameters)
+	resolved.Commands = make([]*Command, 0, len(ac.Commands))
+	for _, cmd := range ac.Commands {
+		resolvedCmd := *cmd
+		resolvedCmd.BuildSettings = cmd.BuildSettings.Override(&environment.BuildSettings)
+		resolved.Commands = append(resolved.Commands, &resolvedCmd)
+	}
+	return &resolved, nil
+}
+
+// EnvironmentNames lists the declared environments in a stable order.
+func (ac *ApplicationConfig) EnvironmentNames() []string {
+	names := make([]string, 0, len(ac.Environments))
+	for name := range ac.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BootstrapOf is the bootstrap config of an environment, nil if it shares the default one.
+func (ac *ApplicationConfig) BootstrapOf(name string) *string {
+	if environment := ac.Environments[name]; environment != nil {
+		return environment.Bootstrap
+	}
+	return nil
+}
+
+// Override applies the settings configured by an environment, they replace those of the command while the
+// env variables are merged.
+func (b BuildSettings) Override(o *BuildSettings) BuildSettings {
+	if len(o.BuildTags) > 0 {
+		b.BuildTags = o.BuildTags
+	}
+	if len(o.Ldflags) > 0 {
+		b.Ldflags = o.Ldflags
+	}
+	if len(o.Gcflags) > 0 {
+		b.Gcflags = o.Gcflags
+	}
+	if o.Cgo != nil {
+		b.Cgo = o.Cgo
+	}
+	if o.VersionVariable != nil {
+		b.VersionVariable = o.VersionVariable
+	}
+	if o.Strip != nil {
+		b.Strip = o.Strip
+	}
+	if len(o.Env) > 0 {
+		b.Env = mergeStrings(b.Env, o.Env)
+	}
+	return b
+}
+
+func mergeStrings(base map[string]string, overrides map[string]string) map[string]string {
+	if len(base) == 0 && len(overrides) == 0 {
+		return base
+	}
+	merged := make(map[string]string, len(base)+len(overrides))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range overrides {
+		merged[key] = value
+	}
+	return merged
+}
+
 func SaveConfig(ac *ApplicationConfig, filePath string) error {
 	// Marshal ApplicationConfig struct to YAML
 	data, err := yaml.Marshal(ac)
@@ -63,9 +280,13 @@ func LoadConfig(filePath string) (*ApplicationConfig, error) {
 		return nil, err
 	}
 
-	// Unmarshal YAML to ApplicationConfig struct
+	if problems := Validate(data); len(problems) > 0 {
+		return nil, &ValidationError{File: filePath, Problems: problems}
+	}
+
+	// Unmarshal YAML to ApplicationConfig struct, unknown fields are rejected
 	var ac ApplicationConfig
-	err = yaml.Unmarshal(data, &ac)
+	err = yaml.UnmarshalStrict(data, &ac)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	// interpolator resolves the variables of one config, git is only asked once per variable.
+	interpolator struct {
+		Dir       string
+		Self      interface{}
+		git       map[string]string
+		resolving map[string]bool
+	}
+)
+
+// variable matches ${source:name}, $${ escapes a literal ${.
+var variable = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+
+// VariableSources lists the sources a variable can be resolved from.
+var VariableSources = []string{"env", "git", "file", "self"}
+
+// Interpolate resolves the variables in every string of the config, files and git are looked up relative to dir:
+//
+//	${env:VAR}     the environment variable VAR, it has to be set
+//	${git:sha}     the commit checked out
+//	${git:branch}  the branch checked out
+//	${file:path}   the content of a file, without trailing line breaks
+//	${self:path}   another value of the config, e.g. ${self:name} or ${self:tags.team}
+//
+// Every variable which cannot be resolved is reported. The resolved config is validated again, as the values of
+// variables are not known when the file is validated.
+func Interpolate(ctx context.Context, ac *ApplicationConfig, dir string) error {
+	data, err := yaml.Marshal(ac)
+	if err != nil {
+		return err
+	}
+	var self interface{}
+	if err := yaml.Unmarshal(data, &self); err != nil {
+		return err
+	}
+	i := &interpolator{
+		Dir:       dir,
+		Self:      self,
+		git:       make(map[string]string),
+		resolving: make(map[string]bool),
+	}
+	if err := errors.Join(i.visit(ctx, reflect.ValueOf(ac).Elem(), "")...); err != nil {
+		return err
+	}
+	return validateResolved(ac)
+}
+
+// validateResolved runs the validation on the resolved config, the problems are named by path as the resolved
+// config has no position in the file.
+func validateResolved(ac *ApplicationConfig) error {
+	data, err := yaml.Marshal(ac)
+	if err != nil {
+		return err
+	}
+	errs := make([]error, 0)
+	for _, problem := range Validate(data) {
+		errs = append(errs, errors.New(problem.Message))
+	}
+	return errors.Join(errs...)
+}
+
+func (i *interpolator) visit(ctx context.Context, value reflect.Value, path string) []error {
+	switch value.Kind() {
+	case reflect.Pointer:
+		if value.IsNil() {
+			return nil
+		}
+		return i.visit(ctx, value.Elem(), path)
+	case reflect.String:
+		resolved, err := i.interpolate(ctx, value.String())
+		if err != nil {
+			return []error{fmt.Errorf("%s: %w", describePath(path), err)}
+		}
+		value.SetString(resolved)
+	case reflect.Slice:
+		errs := make([]error, 0)
+		for index := 0; index < value.Len(); index++ {
+			errs = append(errs, i.visit(ctx, value.Index(index), fmt.Sprintf("%s[%d]", path, index))...)
+		}
+		return errs
+	case reflect.Map:
+		errs := make([]error, 0)
+		keys := value.MapKeys()
+		sort.Slice(keys, func(a, b int) bool { return keys[a].String() < keys[b].String() })
+		for _, key := range keys {
+			entry := value.MapIndex(key)
+			entryPath := joinPath(path, key.String())
+			if entry.Kind() != reflect.String {
+				errs = append(errs, i.visit(ctx, entry, entryPath)...)
+				continue
+			}
+			resolved, err := i.interpolate(ctx, entry.String())
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", entryPath, err))
+				continue
+			}
+			value.SetMapIndex(key, reflect.ValueOf(resolved))
+		}
+		return errs
+	case reflect.Struct:
+		errs := make([]error, 0)
+		for index := 0; index < value.NumField(); index++ {
+			name, inline, skip := yamlProperty(value.Type().Field(index))
+			if skip {
+				continue
+			}
+			fieldPath := path
+			if !inline {
+				fieldPath = joinPath(path, name)
+			}
+			errs = append(errs, i.visit(ctx, value.Field(index), fieldPath)...)
+		}
+		return errs
+	}
+	return nil
+}
+
+func (i *interpolator) interpolate(ctx context.Context, text string) (string, error) {
+	if !strings.Contains(text, "${") {
+		return text, nil
+	}
+	errs := make([]error, 0)
+	resolved := variable.ReplaceAllStringFunc(text, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		value, err := i.resolve(ctx, match[2:len(match)-1])
+		if err != nil {
+			errs = append(errs, err)
+			return match
+		}
+		return value
+	})
+	return resolved, errors.Join(errs...)
+}
+
+func (i *interpolator) resolve(ctx context.Context, expression string) (string, error) {
+	source, name, found := strings.Cut(expression, ":")
+	source, name = strings.TrimSpace(source), strings.TrimSpace(name)
+	if !found || name == "" {
+		return "", fmt.Errorf("unknown variable ${%s}, expected ${source:name} with a source of %v", expression, VariableSources)
+	}
+	switch source {
+	case "env":
+		value, found := os.LookupEnv(name)
+		if !found {
+			return "", fmt.Errorf("environment variable %s of ${%s} is not set", name, expression)
+		}
+		return value, nil
+	case "git":
+		return i.resolveGit(ctx, name)
+	case "file":
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(i.Dir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("could not read ${%s}: %w", expression, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case "self":
+		return i.resolveSelf(ctx, name)
+	}
+	return "", fmt.Errorf("unknown variable ${%s}, the source %s is not one of %v", expression, source, VariableSources)
+}
+
+func (i *interpolator) resolveGit(ctx context.Context, name string) (string, error) {
+	if value, found := i.git[name]; found {
+		return value, nil
+	}
+	var args []string
+	switch name {
+	case "sha":
+		args = []string{"rev-parse", "HEAD"}
+	case "branch":
+		args = []string{"rev-parse", "--abbrev-ref", "HEAD"}
+	default:
+		return "", fmt.Errorf("unknown variable ${git:%s}, expected ${git:sha} or ${git:branch}", name)
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = i.Dir
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("could not resolve ${git:%s}: %w", name, ctx.Err())
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("could not resolve ${git:%s}: %s", name, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("could not resolve ${git:%s}: %w", name, err)
+	}
+	value := strings.TrimSpace(string(output))
+	if name == "branch" && value == "HEAD" {
+		return "", fmt.Errorf("could not resolve ${git:branch}, no branch is checked out")
+	}
+	i.git[name] = value
+	return value, nil
+}
+
+// resolveSelf looks up a dotted path in the config, values referencing other variables are resolved as well.
+func (i *interpolator) resolveSelf(ctx context.Context, path string) (string, error) {
+	if i.resolving[path] {
+		return "", fmt.Errorf("${self:%s} references itself", path)
+	}
+	current := i.Self
+	for _, key := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[interface{}]interface{}:
+			current = node[key]
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return "", fmt.Errorf("unknown variable ${self:%s}, %s is not an index of the list", path, key)
+			}
+			current = node[index]
+		default:
+			current = nil
+		}
+		if current == nil {
+			return "", fmt.Errorf("unknown variable ${self:%s}, the config has no value at this path", path)
+		}
+	}
+	switch current.(type) {
+	case map[interface{}]interface{}, []interface{}:
+		return "", fmt.Errorf("${self:%s} has to reference a single value", path)
+	}
+	i.resolving[path] = true
+	defer delete(i.resolving, path)
+	return i.interpolate(ctx, fmt.Sprint(current))
+}
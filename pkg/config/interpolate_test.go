@@ -0,0 +1,54 @@
+package config
+
+import (
+	"context"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestInterpolateValidatesResolvedValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		arch    string
+		maxSize string
+		want    string
+	}{
+		{
+			name:    "valid",
+			arch:    "arm64",
+			maxSize: "5MiB",
+		},
+		{
+			name:    "unknown architecture",
+			arch:    "arm",
+			maxSize: "5MiB",
+			want:    `architecture has to be one of x86_64, arm64, got "arm"`,
+		},
+		{
+			name:    "invalid size",
+			arch:    "arm64",
+			maxSize: "lots",
+			want:    `commands[0].maxSize: "lots" is not a size, expected e.g. 20MiB, 15MB or a number of bytes`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("GADGET_TEST_ARCH", test.arch)
+			t.Setenv("GADGET_TEST_MAX_SIZE", test.maxSize)
+			data := "name: demo\narchitecture: ${env:GADGET_TEST_ARCH}\ncommands:\n  - name: hello\n    path: ./cmd/hello\n    maxSize: ${env:GADGET_TEST_MAX_SIZE}\n"
+			var ac ApplicationConfig
+			if err := yaml.Unmarshal([]byte(data), &ac); err != nil {
+				t.Fatal(err)
+			}
+			err := Interpolate(context.Background(), &ac, t.TempDir())
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != test.want {
+				t.Errorf("Interpolate() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
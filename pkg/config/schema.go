@@ -0,0 +1,96 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Schema is the subset of JSON Schema needed to describe gadget.yaml, it also drives the validation of the file.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+}
+
+// schemaEnums restricts properties to a fixed set of values, keyed by the property name.
+var schemaEnums = map[string][]string{
+	"architecture":  Architectures,
+	"packageType":   PackageTypes,
+	"architectures": Architectures,
+}
+
+// ApplicationSchema generates the JSON Schema of gadget.yaml from ApplicationConfig.
+func ApplicationSchema() *Schema {
+	schema := schemaOf(reflect.TypeOf(ApplicationConfig{}), "")
+	schema.Schema = "https://json-schema.org/draft/2020-12/schema"
+	schema.Title = "gadget.yaml"
+	return schema
+}
+
+func schemaOf(t reflect.Type, name string) *Schema {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem(), name)
+	case reflect.String:
+		return &Schema{Type: "string", Enum: schemaEnums[name]}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Slice:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem(), name)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), "")}
+	case reflect.Struct:
+		schema := &Schema{
+			Type:                 "object",
+			Properties:           make(map[string]*Schema),
+			AdditionalProperties: false,
+		}
+		addStructProperties(schema, t)
+		return schema
+	}
+	return &Schema{}
+}
+
+// addStructProperties follows the rules of the yaml decoder, inline structs contribute their properties to the
+// enclosing object.
+func addStructProperties(schema *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, inline, skip := yamlProperty(field)
+		if skip {
+			continue
+		}
+		if inline {
+			addStructProperties(schema, field.Type)
+			continue
+		}
+		schema.Properties[name] = schemaOf(field.Type, name)
+		if field.Tag.Get("jsonschema") == "required" {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// yamlProperty names the property of a field like the yaml decoder: the tag names it, untagged fields are lower cased.
+func yamlProperty(field reflect.StructField) (string, bool, bool) {
+	if !field.IsExported() {
+		return "", false, true
+	}
+	tag := strings.Split(field.Tag.Get("yaml"), ",")
+	if len(tag) > 1 && tag[1] == "inline" {
+		return "", true, false
+	}
+	name := tag[0]
+	if name == "-" {
+		return "", false, true
+	}
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name, false, false
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are ordered so longer suffixes are matched first.
+var sizeUnits = []struct {
+	Suffix string
+	Factor int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// ParseSize reads sizes like 20MiB, 15MB or 1048576, a number without unit is in bytes.
+func ParseSize(value string) (int64, error) {
+	trimmed := strings.TrimSpace(value)
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(trimmed, unit.Suffix) {
+			trimmed = strings.TrimSpace(strings.TrimSuffix(trimmed, unit.Suffix))
+			factor = unit.Factor
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(trimmed, 64)
+	// ParseFloat accepts Inf and NaN, they and sizes beyond int64 do not convert to a number of bytes
+	if err != nil || number < 0 || math.IsNaN(number) || number*float64(factor) >= math.MaxInt64 {
+		return 0, fmt.Errorf("%q is not a size, expected e.g. 20MiB, 15MB or a number of bytes", value)
+	}
+	return int64(number * float64(factor)), nil
+}
+
+// FormatSize renders a number of bytes with a binary unit.
+func FormatSize(size int64) string {
+	value := float64(size)
+	if size < 0 {
+		value = -value
+	}
+	for _, unit := range []string{"B", "KiB", "MiB"} {
+		if value < 1024 {
+			return formatSizeValue(size < 0, value, unit)
+		}
+		value /= 1024
+	}
+	return formatSizeValue(size < 0, value, "GiB")
+}
+
+func formatSizeValue(negative bool, value float64, unit string) string {
+	sign := ""
+	if negative {
+		sign = "-"
+	}
+	if unit == "B" {
+		return fmt.Sprintf("%s%.0f %s", sign, value, unit)
+	}
+	return fmt.Sprintf("%s%.1f %s", sign, value, unit)
+}
@@ -0,0 +1,275 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+type (
+	// Problem is a single finding of the validation, positioned in the validated file.
+	Problem struct {
+		Line    int
+		Column  int
+		Message string
+	}
+
+	ValidationError struct {
+		File     string
+		Problems []Problem
+	}
+
+	// validation collects the problems of a file and remembers the node of every path for the semantic checks.
+	validation struct {
+		Problems []Problem
+		Nodes    map[string]*yamlv3.Node
+	}
+)
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%d:%d: %s", p.Line, p.Column, p.Message)
+}
+
+func (e *ValidationError) Error() string {
+	lines := []string{fmt.Sprintf("%s is invalid:", e.File)}
+	for _, problem := range e.Problems {
+		lines = append(lines, fmt.Sprintf("  %s:%s", e.File, problem))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// environmentName keeps the stack name derived from an environment valid for CloudFormation.
+var environmentName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9-]*$`)
+
+// yamlBooleans are the YAML 1.1 spellings the decoder accepts for booleans.
+var yamlBooleans = []string{"y", "yes", "n", "no", "true", "false", "on", "off"}
+
+// Validate checks gadget.yaml against the application schema and the references between its sections.
+// Every problem is reported, not just the first one.
+func Validate(data []byte) []Problem {
+	var document yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &document); err != nil {
+		return []Problem{syntaxProblem(err)}
+	}
+	if len(document.Content) == 0 {
+		return []Problem{{Line: 1, Column: 1, Message: "the file is empty"}}
+	}
+	v := &validation{Nodes: make(map[string]*yamlv3.Node)}
+	root := document.Content[0]
+	v.validateNode(root, ApplicationSchema(), "")
+
+	// The references are checked on whatever decodes, decoding errors are already reported by the schema
+	var conf ApplicationConfig
+	if err := root.Decode(&conf); err != nil && len(v.Problems) == 0 {
+		v.Problems = append(v.Problems, syntaxProblem(err))
+	}
+	v.validateReferences(&conf)
+	return v.sorted()
+}
+
+func syntaxProblem(err error) Problem {
+	problem := Problem{Line: 1, Column: 1, Message: err.Error()}
+	// yaml: line 3: mapping values are not allowed in this context
+	message := strings.TrimPrefix(err.Error(), "yaml: ")
+	if _, err := fmt.Sscanf(message, "line %d:", &problem.Line); err == nil {
+		_, problem.Message, _ = strings.Cut(message, ": ")
+	}
+	return problem
+}
+
+func (v *validation) report(node *yamlv3.Node, format string, args ...interface{}) {
+	if node == nil {
+		node = &yamlv3.Node{Line: 1, Column: 1}
+	}
+	v.Problems = append(v.Problems, Problem{Line: node.Line, Column: node.Column, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validation) sorted() []Problem {
+	sort.SliceStable(v.Problems, func(i, j int) bool {
+		if v.Problems[i].Line == v.Problems[j].Line {
+			return v.Problems[i].Column < v.Problems[j].Column
+		}
+		return v.Problems[i].Line < v.Problems[j].Line
+	})
+	return v.Problems
+}
+
+func (v *validation) validateNode(node *yamlv3.Node, schema *Schema, path string) {
+	if node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+	v.Nodes[path] = node
+	if node.Kind == yamlv3.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+	switch schema.Type {
+	case "object":
+		v.validateMapping(node, schema, path)
+	case "array":
+		if node.Kind != yamlv3.SequenceNode {
+			v.report(node, "%s has to be a list", describePath(path))
+			return
+		}
+		for i, item := range node.Content {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			if isNull(item) {
+				v.report(item, "%s is empty", itemPath)
+				continue
+			}
+			v.validateNode(item, schema.Items, itemPath)
+		}
+	case "string":
+		if node.Kind != yamlv3.ScalarNode {
+			v.report(node, "%s has to be a string", describePath(path))
+			return
+		}
+		// Variables are only resolved when the config is loaded, Interpolate validates the resolved values again
+		if len(schema.Enum) > 0 && !contains(schema.Enum, node.Value) && !strings.Contains(node.Value, "${") {
+			v.report(node, "%s has to be one of %s, got %q", describePath(path), strings.Join(schema.Enum, ", "), node.Value)
+		}
+	case "boolean":
+		if node.Kind != yamlv3.ScalarNode || (node.Tag != "!!bool" && !contains(yamlBooleans, strings.ToLower(node.Value))) {
+			v.report(node, "%s has to be true or false", describePath(path))
+		}
+	}
+}
+
+func (v *validation) validateMapping(node *yamlv3.Node, schema *Schema, path string) {
+	if node.Kind != yamlv3.MappingNode {
+		v.report(node, "%s has to be a mapping", describePath(path))
+		return
+	}
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		childPath := joinPath(path, key.Value)
+		if seen[key.Value] {
+			v.report(key, "%s is declared twice", describePath(childPath))
+			continue
+		}
+		seen[key.Value] = true
+		if property, found := schema.Properties[key.Value]; found {
+			v.validateNode(value, property, childPath)
+			continue
+		}
+		if additional, ok := schema.AdditionalProperties.(*Schema); ok {
+			v.validateNode(value, additional, childPath)
+			continue
+		}
+		message := fmt.Sprintf("unknown field %q", key.Value)
+		if suggestion := closestProperty(key.Value, schema); suggestion != "" {
+			message += fmt.Sprintf(", did you mean %q?", suggestion)
+		}
+		v.report(key, "%s", message)
+	}
+	for _, required := range schema.Required {
+		if !seen[required] || isNull(v.Nodes[joinPath(path, required)]) {
+			v.report(node, "%s is missing the required field %q", describePath(path), required)
+		}
+	}
+}
+
+// validateReferences checks what the schema cannot express: unique names, references and sizes.
+func (v *validation) validateReferences(conf *ApplicationConfig) {
+	layers := make(map[string]bool)
+	for i, layer := range conf.Layers {
+		if layer == nil || layer.Name == nil {
+			continue
+		}
+		if layers[*layer.Name] {
+			v.report(v.Nodes[fmt.Sprintf("layers[%d].name", i)], "layer %s is declared twice", *layer.Name)
+		}
+		layers[*layer.Name] = true
+	}
+	for name := range conf.Environments {
+		if !environmentName.MatchString(name) {
+			v.report(v.Nodes["environments."+name], "environment %s has to consist of letters, digits and hyphens, it becomes part of the stack name", name)
+		}
+	}
+	commands := make(map[string]bool)
+	for i, command := range conf.Commands {
+		if command == nil || command.Name == nil {
+			continue
+		}
+		commandPath := fmt.Sprintf("commands[%d]", i)
+		if commands[*command.Name] {
+			v.report(v.Nodes[commandPath+".name"], "command %s is declared twice", *command.Name)
+		}
+		commands[*command.Name] = true
+		if command.PackageType != nil && *command.PackageType == PackageTypeImage && len(command.Layers) > 0 {
+			v.report(v.Nodes[commandPath+".layers"], "command %s is packaged as image, images cannot use layers", *command.Name)
+		}
+		for j, layer := range command.Layers {
+			if !layers[layer] {
+				v.report(v.Nodes[fmt.Sprintf("%s.layers[%d]", commandPath, j)], "command %s uses the undeclared layer %s", *command.Name, layer)
+			}
+		}
+		if command.MaxSize != nil && !strings.Contains(*command.MaxSize, "${") {
+			if _, err := ParseSize(*command.MaxSize); err != nil {
+				v.report(v.Nodes[commandPath+".maxSize"], "%s.maxSize: %s", commandPath, err)
+			}
+		}
+	}
+}
+
+func isNull(node *yamlv3.Node) bool {
+	return node == nil || (node.Kind == yamlv3.ScalarNode && node.Tag == "!!null")
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func describePath(path string) string {
+	if path == "" {
+		return "the application"
+	}
+	return path
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// closestProperty suggests a known property for a misspelled one, if it is only a few edits away.
+func closestProperty(key string, schema *Schema) string {
+	best, bestDistance := "", 3
+	for property := range schema.Properties {
+		distance := editDistance(strings.ToLower(key), strings.ToLower(property))
+		if distance < bestDistance || (distance == bestDistance && best != "" && property < best) {
+			best, bestDistance = property, distance
+		}
+	}
+	return best
+}
+
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want []string
+	}{
+		{
+			name: "valid",
+			yaml: "name: demo\ncommands:\n  - name: hello\n    path: ./cmd/hello\n",
+			want: []string{},
+		},
+		{
+			name: "null command",
+			yaml: "name: demo\ncommands:\n  -\n",
+			want: []string{`3:4: commands[0] is empty`},
+		},
+		{
+			name: "null layer next to a command using it",
+			yaml: "name: demo\nlayers:\n  -\ncommands:\n  - name: hello\n    path: ./cmd/hello\n    layers: [tools]\n",
+			want: []string{
+				`3:4: layers[0] is empty`,
+				`7:14: command hello uses the undeclared layer tools`,
+			},
+		},
+		{
+			name: "missing name and path",
+			yaml: "commands:\n  - name: hello\n",
+			want: []string{
+				`1:1: the application is missing the required field "name"`,
+				`2:5: commands[0] is missing the required field "path"`,
+			},
+		},
+		{
+			name: "unknown fields with suggestions",
+			yaml: "name: demo\narchitecure: arm64\ncommands:\n  - name: hello\n    path: ./cmd/hello\n    ldflag: [-s]\n    bogus: true\n",
+			want: []string{
+				`2:1: unknown field "architecure", did you mean "architecture"?`,
+				`6:5: unknown field "ldflag", did you mean "ldflags"?`,
+				`7:5: unknown field "bogus"`,
+			},
+		},
+		{
+			name: "duplicate names",
+			yaml: "name: demo\nlayers:\n  - name: tools\n    path: ./a\n  - name: tools\n    path: ./b\ncommands:\n  - name: hello\n    path: ./a\n  - name: hello\n    path: ./b\n",
+			want: []string{
+				`5:11: layer tools is declared twice`,
+				`10:11: command hello is declared twice`,
+			},
+		},
+		{
+			name: "reference problems next to schema problems",
+			yaml: "name: demo\ncommands:\n  - name: hello\n    path: ./cmd/hello\n    cgo: maybe\n    maxSize: lots\n    layers: [tools]\n",
+			want: []string{
+				`5:10: commands[0].cgo has to be true or false`,
+				`6:14: commands[0].maxSize: "lots" is not a size, expected e.g. 20MiB, 15MB or a number of bytes`,
+				`7:14: command hello uses the undeclared layer tools`,
+			},
+		},
+		{
+			name: "layers on an image",
+			yaml: "name: demo\nlayers:\n  - name: tools\n    path: ./tools\ncommands:\n  - name: hello\n    path: ./cmd/hello\n    packageType: image\n    layers: [tools]\n",
+			want: []string{`9:13: command hello is packaged as image, images cannot use layers`},
+		},
+		{
+			name: "non finite size",
+			yaml: "name: demo\ncommands:\n  - name: hello\n    path: ./cmd/hello\n    maxSize: Inf\n",
+			want: []string{`5:14: commands[0].maxSize: "Inf" is not a size, expected e.g. 20MiB, 15MB or a number of bytes`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, problem := range Validate([]byte(test.yaml)) {
+				got = append(got, problem.String())
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Validate() =\n%q\nwant\n%q", got, test.want)
+			}
+		})
+	}
+}